@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// ACCOUNTSTRUCT binds a foodie UserId to the X.509 identity that owns it. A Closed account has
+// had its balances moved to SuccessorUserId by ReassignAccount.
+type ACCOUNTSTRUCT struct {
+	UserID          string `json:"UserId"`
+	ClientID        string `json:"ClientId"`
+	MSPID           string `json:"MSPId"`
+	DocType         string `json:"DocType"`
+	Closed          bool   `json:"Closed"`
+	SuccessorUserID string `json:"SuccessorUserId"`
+}
+
+const ACCOUNT = "ACCOUNT"
+
+// ENROLLMENTIDATTR is the certificate attribute in which the Fabric CA records the enrollment ID
+// an identity was issued for.
+const ENROLLMENTIDATTR = "hf.EnrollmentID"
+
+// RegisterAccount binds the calling identity (MSPID + X.509 ID) to the given foodie UserId.
+// The UserId must be the enrollment ID the CA issued the caller's certificate for, so an identity
+// cannot claim another user's balances. A UserId can only be claimed once and an identity can
+// only own a single UserId.
+func (s *SmartContract) RegisterAccount(ctx contractapi.TransactionContextInterface, userId string) error {
+	if userId == "" {
+		return newError(ERRINVALIDINPUT, "user id must not be empty")
+	}
+
+	// Retrieve the caller's identity
+	clientMSPID, clientID, err := getClientIdentity(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Ensure the caller was enrolled as userId
+	enrollmentID, found, err := ctx.GetClientIdentity().GetAttributeValue(ENROLLMENTIDATTR)
+	if err != nil {
+		return fmt.Errorf("failed to get enrollment ID: %w", err)
+	}
+	if !found {
+		return newError(ERRUNAUTHORIZED, "client certificate does not carry an enrollment ID")
+	}
+	if enrollmentID != userId {
+		return newError(ERRUNAUTHORIZED, "client is enrolled as %s and cannot register account %s", enrollmentID, userId)
+	}
+
+	// Ensure the UserId has not already been claimed
+	existingAccount, err := getAccount(ctx, userId)
+	if err != nil {
+		return err
+	}
+	if existingAccount != nil {
+		return newError(ERRDUPLICATE, "account %s is already registered", userId)
+	}
+
+	// Ensure the caller does not already own an account
+	identityKey, err := ctx.GetStub().CreateCompositeKey(DOCTYPE+"~Identity", []string{clientMSPID, clientID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key for identity: %w", err)
+	}
+
+	checkIdentityEntry, err := ctx.GetStub().GetState(identityKey)
+	if err != nil {
+		return fmt.Errorf("failed to fetch identity entry: %w", err)
+	}
+	if checkIdentityEntry != nil {
+		return newError(ERRDUPLICATE, "client identity is already bound to an account")
+	}
+
+	var account ACCOUNTSTRUCT
+	account.UserID = userId
+	account.ClientID = clientID
+	account.MSPID = clientMSPID
+	account.DocType = ACCOUNT
+
+	// Store the account under both the UserId and the identity so it can be resolved either way
+	return putAccount(ctx, &account)
+}
+
+// GetAccount returns the identity binding registered for a foodie UserId.
+func (s *SmartContract) GetAccount(ctx contractapi.TransactionContextInterface, userId string) (*ACCOUNTSTRUCT, error) {
+	account, err := getAccount(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if account == nil {
+		return nil, newError(ERRNOTFOUND, "account %s does not exist", userId)
+	}
+
+	return account, nil
+}
+
+// putAccount stores account under both its UserId and its identity.
+func putAccount(ctx contractapi.TransactionContextInterface, account *ACCOUNTSTRUCT) error {
+	accountAsByte, err := json.Marshal(account)
+	if err != nil {
+		return fmt.Errorf("failed to marshal account: %w", err)
+	}
+
+	accountKey, err := ctx.GetStub().CreateCompositeKey(DOCTYPE+"~Account", []string{account.UserID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key for account: %w", err)
+	}
+
+	identityKey, err := ctx.GetStub().CreateCompositeKey(DOCTYPE+"~Identity", []string{account.MSPID, account.ClientID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key for identity: %w", err)
+	}
+
+	err = ctx.GetStub().PutState(accountKey, accountAsByte)
+	if err != nil {
+		return fmt.Errorf("failed to store account state: %v", err)
+	}
+
+	err = ctx.GetStub().PutState(identityKey, accountAsByte)
+	if err != nil {
+		return fmt.Errorf("failed to store identity state: %v", err)
+	}
+
+	return nil
+}
+
+// getAccount reads the account registered for userId, returning nil if there is none.
+func getAccount(ctx contractapi.TransactionContextInterface, userId string) (*ACCOUNTSTRUCT, error) {
+	accountKey, err := ctx.GetStub().CreateCompositeKey(DOCTYPE+"~Account", []string{userId})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key for account: %w", err)
+	}
+
+	accountAsByte, err := ctx.GetStub().GetState(accountKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch account: %w", err)
+	}
+	if accountAsByte == nil {
+		return nil, nil
+	}
+
+	var account ACCOUNTSTRUCT
+	err = json.Unmarshal(accountAsByte, &account)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal account: %w", err)
+	}
+
+	return &account, nil
+}
+
+// assertAccountRegistered fails with NOT_FOUND unless userId is a registered account, so tokens are
+// never credited to a UserId nobody owns.
+func assertAccountRegistered(ctx contractapi.TransactionContextInterface, userId string) error {
+	account, err := getAccount(ctx, userId)
+	if err != nil {
+		return err
+	}
+	if account == nil {
+		return newError(ERRNOTFOUND, "account %s does not exist", userId).withDetail("userId", userId)
+	}
+
+	return nil
+}
+
+// getClientIdentity returns the MSPID and X.509 ID of the submitting client.
+func getClientIdentity(ctx contractapi.TransactionContextInterface) (string, string, error) {
+	clientMSPID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get MSPID: %w", err)
+	}
+
+	clientID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get client ID: %w", err)
+	}
+
+	return clientMSPID, clientID, nil
+}
+
+// getCallerUserID resolves the foodie UserId bound to the submitting client. A client whose account
+// was closed by ReassignAccount is refused.
+func getCallerUserID(ctx contractapi.TransactionContextInterface) (string, error) {
+	account, err := lookupCallerAccount(ctx)
+	if err != nil {
+		return "", err
+	}
+	if account == nil {
+		return "", newError(ERRUNAUTHORIZED, "client identity is not registered to a foodie account")
+	}
+	if account.Closed {
+		return "", newError(ERRUNAUTHORIZED, "account %s is closed", account.UserID).
+			withDetail("userId", account.UserID).
+			withDetail("successorUserId", account.SuccessorUserID)
+	}
+
+	return account.UserID, nil
+}
+
+// lookupCallerAccount reads the account bound to the submitting client, returning nil for an
+// unregistered client instead of failing.
+func lookupCallerAccount(ctx contractapi.TransactionContextInterface) (*ACCOUNTSTRUCT, error) {
+	clientMSPID, clientID, err := getClientIdentity(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	identityKey, err := ctx.GetStub().CreateCompositeKey(DOCTYPE+"~Identity", []string{clientMSPID, clientID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key for identity: %w", err)
+	}
+
+	accountAsByte, err := ctx.GetStub().GetState(identityKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch identity entry: %w", err)
+	}
+	if accountAsByte == nil {
+		return nil, nil
+	}
+
+	var account ACCOUNTSTRUCT
+	err = json.Unmarshal(accountAsByte, &account)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal account: %w", err)
+	}
+
+	return &account, nil
+}
+
+// assertAccountOwner fails unless the submitting client owns the given foodie UserId.
+func assertAccountOwner(ctx contractapi.TransactionContextInterface, userId string) error {
+	callerUserID, err := getCallerUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	if callerUserID != userId {
+		return newError(ERRUNAUTHORIZED, "client is not the owner of account %s", userId)
+	}
+
+	return nil
+}
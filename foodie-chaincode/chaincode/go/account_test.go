@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// testIdentity is a client identity enrolled by the CA as EnrollmentID.
+type testIdentity struct {
+	EnrollmentID string
+	MSPID        string
+	Attributes   map[string]string
+}
+
+func newTestIdentity(enrollmentID string) *testIdentity {
+	return &testIdentity{
+		EnrollmentID: enrollmentID,
+		MSPID:        "Org1MSP",
+		Attributes:   map[string]string{ENROLLMENTIDATTR: enrollmentID},
+	}
+}
+
+func (i *testIdentity) GetID() (string, error) {
+	return fmt.Sprintf("x509::CN=%s::CN=ca.org1", i.EnrollmentID), nil
+}
+
+func (i *testIdentity) GetMSPID() (string, error) {
+	return i.MSPID, nil
+}
+
+func (i *testIdentity) GetAttributeValue(attrName string) (string, bool, error) {
+	value, found := i.Attributes[attrName]
+	return value, found, nil
+}
+
+func (i *testIdentity) AssertAttributeValue(attrName string, attrValue string) error {
+	if value, found := i.Attributes[attrName]; !found || value != attrValue {
+		return fmt.Errorf("attribute %s does not have value %s", attrName, attrValue)
+	}
+	return nil
+}
+
+func (i *testIdentity) GetX509Certificate() (*x509.Certificate, error) {
+	return nil, nil
+}
+
+// runAs is run with identity as the submitting client.
+func (l *balanceTestLedger) runAs(identity *testIdentity, fn func(ctx contractapi.TransactionContextInterface) error) error {
+	return l.run(func(ctx contractapi.TransactionContextInterface) error {
+		ctx.(*contractapi.TransactionContext).SetClientIdentity(identity)
+		return fn(ctx)
+	})
+}
+
+// registerAccount registers userId through RegisterAccount and grants it roles.
+func (l *balanceTestLedger) registerAccount(userId string, roles ...string) {
+	l.t.Helper()
+	err := l.runAs(newTestIdentity(userId), func(ctx contractapi.TransactionContextInterface) error {
+		err := new(SmartContract).RegisterAccount(ctx, userId)
+		if err != nil {
+			return err
+		}
+		for _, role := range roles {
+			err = putRole(ctx, userId, role, "admin")
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		l.t.Fatal(err)
+	}
+}
+
+func assertErrorCode(t *testing.T, err error, code string) {
+	t.Helper()
+	if err == nil {
+		t.Fatalf("expected code %s, got no error", code)
+	}
+	if decoded := decodeChaincodeError(t, err); decoded.Code != code {
+		t.Fatalf("expected code %s, got %s: %s", code, decoded.Code, decoded.Message)
+	}
+}
+
+func TestRegisterAccountRequiresMatchingEnrollmentID(t *testing.T) {
+	ledger := newBalanceTestLedger(t)
+	register := func(identity *testIdentity, userId string) error {
+		return ledger.runAs(identity, func(ctx contractapi.TransactionContextInterface) error {
+			return new(SmartContract).RegisterAccount(ctx, userId)
+		})
+	}
+
+	assertErrorCode(t, register(newTestIdentity("mallory"), "alice"), ERRUNAUTHORIZED)
+
+	unenrolled := newTestIdentity("alice")
+	unenrolled.Attributes = map[string]string{}
+	assertErrorCode(t, register(unenrolled, "alice"), ERRUNAUTHORIZED)
+
+	if err := register(newTestIdentity("alice"), "alice"); err != nil {
+		t.Fatal(err)
+	}
+	assertErrorCode(t, register(newTestIdentity("alice"), "alice"), ERRDUPLICATE)
+}
+
+func TestTransferAndBurnRejectCallersNotOwningTheAccount(t *testing.T) {
+	ledger := newBalanceTestLedger(t)
+	ledger.createToken(TOKENDEF{ID: "MEAL", IssuerOrg: "Org1MSP", Transferable: true, DocType: TOKEN})
+	ledger.registerAccount("alice", STUDENTROLE)
+	ledger.registerAccount("mallory", STUDENTROLE, BURNERROLE)
+	if err := ledger.add("alice", "MEAL", "10"); err != nil {
+		t.Fatal(err)
+	}
+
+	err := ledger.runAs(newTestIdentity("mallory"), func(ctx contractapi.TransactionContextInterface) error {
+		_, err := new(SmartContract).TransferTyped(ctx, TRANSFER{TxnID: "t1", ID: "MEAL", UserId: "alice", Receiver: "mallory", Amount: "10"})
+		return err
+	})
+	assertErrorCode(t, err, ERRUNAUTHORIZED)
+
+	err = ledger.runAs(newTestIdentity("mallory"), func(ctx contractapi.TransactionContextInterface) error {
+		_, err := new(SmartContract).BurnTyped(ctx, BURNTOKEN{TxnID: "b1", ID: "MEAL", BurnTokenID: "alice", BurnTokenAmount: "10"})
+		return err
+	})
+	assertErrorCode(t, err, ERRUNAUTHORIZED)
+
+	if balance := ledger.balance("alice", "MEAL"); balance != "10" {
+		t.Fatalf("expected balance 10, got %s", balance)
+	}
+}
+
+func TestTransferRejectsUnregisteredReceivers(t *testing.T) {
+	ledger := newBalanceTestLedger(t)
+	ledger.createToken(TOKENDEF{ID: "MEAL", IssuerOrg: "Org1MSP", Transferable: true, DocType: TOKEN})
+	ledger.registerAccount("alice", STUDENTROLE)
+	if err := ledger.add("alice", "MEAL", "10"); err != nil {
+		t.Fatal(err)
+	}
+
+	err := ledger.runAs(newTestIdentity("alice"), func(ctx contractapi.TransactionContextInterface) error {
+		_, err := new(SmartContract).TransferTyped(ctx, TRANSFER{TxnID: "t1", ID: "MEAL", UserId: "alice", Receiver: "bob", Amount: "5"})
+		return err
+	})
+	assertErrorCode(t, err, ERRNOTFOUND)
+
+	if balance := ledger.balance("alice", "MEAL"); balance != "10" {
+		t.Fatalf("expected balance 10, got %s", balance)
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// ALLOWANCESTRUCT is the amount of token Id that Spender may still move out of Owner's account.
+type ALLOWANCESTRUCT struct {
+	ID      string `json:"Id"`
+	Owner   string `json:"Owner"`
+	Spender string `json:"Spender"`
+	DocType string `json:"DocType"`
+	Amount  AMOUNT `json:"Amount"`
+}
+
+const ALLOWANCE = "ALLOWANCE"
+const TRANSFERFROM = "TransferFrom"
+
+// Approve sets the amount of token id that spender may transfer out of the caller's account,
+// replacing any previous allowance. An amount of zero removes the allowance.
+func (s *SmartContract) Approve(ctx contractapi.TransactionContextInterface, spender string, id string, amount string) error {
+	// The caller approves spending from its own account
+	owner, err := getCallerUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	allowance, err := newAmount(amount)
+	if err != nil {
+		return err
+	}
+	sign, err := compareAmounts(allowance, "0")
+	if err != nil {
+		return err
+	}
+	if sign < 0 {
+		return newError(ERRINVALIDINPUT, "allowance amount must not be negative")
+	}
+	if spender == "" || spender == owner {
+		return newError(ERRINVALIDINPUT, "spender must be another account")
+	}
+
+	// Ensure the spender is a registered account that is still open
+	account, err := getAccount(ctx, spender)
+	if err != nil {
+		return err
+	}
+	if account == nil || account.Closed {
+		return newError(ERRNOTFOUND, "account %s does not exist or is closed", spender).withDetail("userId", spender)
+	}
+
+	// Ensure the token is registered and can be transferred
+	token, err := getRegisteredToken(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !token.Transferable {
+		return newError(ERRINVALIDINPUT, "token %s is not transferable", token.ID)
+	}
+
+	return putAllowance(ctx, owner, spender, id, allowance)
+}
+
+// Allowance returns the amount of token id that spender may still transfer out of owner's account.
+// Only the owner, the spender, an Admin or an Auditor can read it.
+func (s *SmartContract) Allowance(ctx contractapi.TransactionContextInterface, owner string, spender string, id string) (AMOUNT, error) {
+	err := assertAccountOwner(ctx, owner)
+	if err != nil {
+		err = assertAccountOwner(ctx, spender)
+	}
+	if err != nil {
+		_, err = assertCallerRole(ctx, ADMINROLE, AUDITORROLE)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return getAllowance(ctx, owner, spender, id)
+}
+
+// TransferFrom moves amount of token id from owner to receiver on behalf of owner, spending the
+// caller's allowance. The allowance is decremented in the same transaction as the balance update.
+// txnId is the client transaction id the transfer is recorded under; retries are handled like
+// Transfer.
+func (s *SmartContract) TransferFrom(ctx contractapi.TransactionContextInterface, txnId string, owner string, receiver string, id string, amount string) (*RECEIPT, error) {
+	transferAmount, err := newAmount(amount)
+	if err != nil {
+		return nil, err
+	}
+
+	var txn TRANSFER
+	txn.TxnID = txnId
+	txn.ID = id
+	txn.UserId = owner
+	txn.Receiver = receiver
+	txn.Amount = transferAmount
+	err = validateTransferInput(&txn)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ensure the caller is allowed to spend, like Transfer; the caller spends its own allowance
+	spender, err := assertCallerRole(ctx, STUDENTROLE, MERCHANTROLE)
+	if err != nil {
+		return nil, err
+	}
+	txn.Spender = spender
+
+	// Return the original receipt if this request was already applied
+	receipt, requestHash, err := checkReceipt(ctx, txn.TxnID, TRANSFERFROM, &txn)
+	if err != nil {
+		return nil, err
+	}
+	if receipt != nil {
+		return receipt, nil
+	}
+
+	// Refuse the request while the contract or the token is paused
+	err = assertNotPaused(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ensure the token is registered and can be transferred
+	token, err := getRegisteredToken(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Transferable {
+		return nil, newError(ERRINVALIDINPUT, "token %s is not transferable", token.ID)
+	}
+
+	// Ensure the receiver is an account someone owns
+	err = assertAccountRegistered(ctx, receiver)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ensure the spender has enough allowance left
+	allowance, err := getAllowance(ctx, owner, spender, id)
+	if err != nil {
+		return nil, err
+	}
+	remaining, err := subAmounts(allowance, transferAmount)
+	if err != nil {
+		return nil, newError(ERRINSUFFICIENTFUNDS, "insufficient allowance for spender %s", spender)
+	}
+
+	txn.DocType = TRANSFERTXN
+	txn.Timestamp, err = getRecordTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	indexName := "TxnID~" + DOCTYPE
+	TxnCompositeKey, err := ctx.GetStub().CreateCompositeKey(indexName, []string{txn.TxnID, txn.ID})
+	if err != nil {
+		return nil, err
+	}
+
+	// Check for duplicate transactions
+	checkTxnDuplication, err := ctx.GetStub().GetState(TxnCompositeKey)
+	if err != nil {
+		return nil, fmt.Errorf("error checking transaction duplication: %w", err)
+	}
+	if checkTxnDuplication != nil {
+		return nil, newError(ERRDUPLICATE, "duplicate transaction")
+	}
+
+	// Decrement the allowance and move the balance
+	err = putAllowance(ctx, owner, spender, id, remaining)
+	if err != nil {
+		return nil, err
+	}
+
+	err = removeBalance(ctx, owner, id, transferAmount)
+	if err != nil {
+		return nil, err
+	}
+
+	err = addBalance(ctx, receiver, id, transferAmount)
+	if err != nil {
+		return nil, err
+	}
+
+	err = moveLots(ctx, owner, receiver, id, transferAmount)
+	if err != nil {
+		return nil, err
+	}
+
+	TXNAsByte, err := json.Marshal(txn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal transfer: %w", err)
+	}
+
+	err = ctx.GetStub().PutState(TxnCompositeKey, TXNAsByte)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store composite key state: %v", err)
+	}
+
+	// Index the transfer under both accounts
+	err = indexTxn(ctx, TxnCompositeKey, txn.ID, txn.UserId, txn.Receiver, txn.Timestamp, txn.TxnID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Emit the transfer event
+	legs := []EVENTLEG{{ID: txn.ID, From: txn.UserId, To: txn.Receiver, Amount: txn.Amount}}
+	err = emitEvent(ctx, TRANSFEREVENT, txn.TxnID, legs)
+	if err != nil {
+		return nil, err
+	}
+
+	return putReceipt(ctx, txn.TxnID, TRANSFERFROM, requestHash, legs)
+}
+
+func getAllowance(ctx contractapi.TransactionContextInterface, owner string, spender string, id string) (AMOUNT, error) {
+	allowanceKey, err := ctx.GetStub().CreateCompositeKey(DOCTYPE+"~Allowance", []string{id, owner, spender})
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key for allowance: %w", err)
+	}
+
+	allowanceAsByte, err := ctx.GetStub().GetState(allowanceKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch allowance: %w", err)
+	}
+	if allowanceAsByte == nil {
+		return "0", nil
+	}
+
+	var allowance ALLOWANCESTRUCT
+	err = json.Unmarshal(allowanceAsByte, &allowance)
+	if err != nil {
+		return "", fmt.Errorf("failed to unmarshal allowance: %w", err)
+	}
+
+	return allowance.Amount, nil
+}
+
+// putAllowance stores the allowance, deleting the entry when amount is zero.
+func putAllowance(ctx contractapi.TransactionContextInterface, owner string, spender string, id string, amount AMOUNT) error {
+	allowanceKey, err := ctx.GetStub().CreateCompositeKey(DOCTYPE+"~Allowance", []string{id, owner, spender})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key for allowance: %w", err)
+	}
+
+	if amount == "0" {
+		err = ctx.GetStub().DelState(allowanceKey)
+		if err != nil {
+			return fmt.Errorf("failed to delete allowance state: %v", err)
+		}
+		return nil
+	}
+
+	var allowance ALLOWANCESTRUCT
+	allowance.ID = id
+	allowance.Owner = owner
+	allowance.Spender = spender
+	allowance.Amount = amount
+	allowance.DocType = ALLOWANCE
+
+	allowanceAsByte, err := json.Marshal(allowance)
+	if err != nil {
+		return fmt.Errorf("failed to marshal allowance: %w", err)
+	}
+
+	err = ctx.GetStub().PutState(allowanceKey, allowanceAsByte)
+	if err != nil {
+		return fmt.Errorf("failed to store allowance state: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func TestTransferFromChecksRoleAndIsIdempotent(t *testing.T) {
+	ledger := newBalanceTestLedger(t)
+	ledger.createToken(TOKENDEF{ID: "MEAL", IssuerOrg: "Org1MSP", Transferable: true, DocType: TOKEN})
+	ledger.registerAccount("alice", STUDENTROLE)
+	ledger.registerAccount("canteen", MERCHANTROLE)
+	ledger.registerAccount("bob")
+	if err := ledger.add("alice", "MEAL", "10"); err != nil {
+		t.Fatal(err)
+	}
+	err := ledger.run(func(ctx contractapi.TransactionContextInterface) error {
+		err := putAllowance(ctx, "alice", "canteen", "MEAL", "6")
+		if err != nil {
+			return err
+		}
+		return putAllowance(ctx, "alice", "bob", "MEAL", "6")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transferFrom := func(spender string, txnId string) (*RECEIPT, error) {
+		var receipt *RECEIPT
+		err := ledger.runAs(newTestIdentity(spender), func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			receipt, err = new(SmartContract).TransferFrom(ctx, txnId, "alice", "canteen", "MEAL", "4")
+			return err
+		})
+		return receipt, err
+	}
+
+	// bob holds an allowance but no role that may spend
+	_, err = transferFrom("bob", "tf1")
+	assertErrorCode(t, err, ERRUNAUTHORIZED)
+
+	first, err := transferFrom("canteen", "tf2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	retry, err := transferFrom("canteen", "tf2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retry.FabricTxID != first.FabricTxID {
+		t.Fatalf("expected the retry to return the original receipt, got %s and %s", first.FabricTxID, retry.FabricTxID)
+	}
+
+	if balance := ledger.balance("alice", "MEAL"); balance != "6" {
+		t.Fatalf("expected balance 6, got %s", balance)
+	}
+	err = ledger.run(func(ctx contractapi.TransactionContextInterface) error {
+		allowance, err := getAllowance(ctx, "alice", "canteen", "MEAL")
+		if err == nil && allowance != "2" {
+			t.Fatalf("expected allowance 2, got %s", allowance)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestApproveRequiresAnOpenSpenderAccount(t *testing.T) {
+	ledger := newBalanceTestLedger(t)
+	ledger.createToken(TOKENDEF{ID: "MEAL", IssuerOrg: "Org1MSP", Transferable: true, DocType: TOKEN})
+	ledger.registerAccount("alice", STUDENTROLE)
+	ledger.registerAccount("canteen", MERCHANTROLE)
+	ledger.registerAccount("bob")
+
+	approve := func(spender string) error {
+		return ledger.runAs(newTestIdentity("alice"), func(ctx contractapi.TransactionContextInterface) error {
+			return new(SmartContract).Approve(ctx, spender, "MEAL", "5")
+		})
+	}
+
+	assertErrorCode(t, approve("nobody"), ERRNOTFOUND)
+
+	err := ledger.run(func(ctx contractapi.TransactionContextInterface) error {
+		account, err := getAccount(ctx, "bob")
+		if err != nil {
+			return err
+		}
+		account.Closed = true
+		return putAccount(ctx, account)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertErrorCode(t, approve("bob"), ERRNOTFOUND)
+
+	if err := approve("canteen"); err != nil {
+		t.Fatal(err)
+	}
+}
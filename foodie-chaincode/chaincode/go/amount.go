@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"math/big"
+	"regexp"
+)
+
+// AMOUNT is a token quantity in the token's base units (see TOKENDEF Decimals), stored as a decimal
+// integer string so it is not limited to 64 bits. Records written before amounts were strings hold
+// JSON numbers, which are still read.
+type AMOUNT string
+
+// MAXAMOUNTBITS bounds every balance, supply and allowance to an unsigned 256 bit integer.
+const MAXAMOUNTBITS = 256
+
+var maxAmount = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), MAXAMOUNTBITS), big.NewInt(1))
+
+var amountPattern = regexp.MustCompile(`^[+-]?[0-9]+$`)
+
+// UnmarshalJSON accepts an integer either as a JSON string or as a JSON number and stores it in
+// canonical form. Fractions and exponents are rejected.
+func (a *AMOUNT) UnmarshalJSON(data []byte) error {
+	var value string
+	var err error
+	if len(data) > 0 && data[0] == '"' {
+		err = json.Unmarshal(data, &value)
+		if err != nil {
+			return err
+		}
+	} else {
+		var number json.Number
+		err = json.Unmarshal(data, &number)
+		if err != nil {
+			return newError(ERRINVALIDINPUT, "invalid amount %s", string(data))
+		}
+		value = number.String()
+	}
+
+	*a, err = newAmount(value)
+	return err
+}
+
+// newAmount validates an amount passed as a transaction argument and returns it in canonical form.
+func newAmount(value string) (AMOUNT, error) {
+	parsed, err := parseAmount(AMOUNT(value))
+	if err != nil {
+		return "", err
+	}
+
+	return toAmount(parsed), nil
+}
+
+// parseAmount converts a to a big integer. An empty amount is zero. Negative values are allowed so
+// deltas and statement lines can be represented; use the checked helpers for balances.
+func parseAmount(a AMOUNT) (*big.Int, error) {
+	if a == "" {
+		return new(big.Int), nil
+	}
+	if !amountPattern.MatchString(string(a)) {
+		return nil, newError(ERRINVALIDINPUT, "invalid amount %q, expected an integer number of base units", string(a))
+	}
+
+	value, ok := new(big.Int).SetString(string(a), 10)
+	if !ok {
+		return nil, newError(ERRINVALIDINPUT, "invalid amount %q, expected an integer number of base units", string(a))
+	}
+	if value.CmpAbs(maxAmount) > 0 {
+		return nil, newError(ERRINVALIDINPUT, "amount %s exceeds the maximum of %d bits", string(a), MAXAMOUNTBITS)
+	}
+
+	return value, nil
+}
+
+func toAmount(value *big.Int) AMOUNT {
+	return AMOUNT(value.String())
+}
+
+// checkedAmount converts the result of an amount calculation, rejecting negative results and
+// results past the maximum.
+func checkedAmount(value *big.Int) (AMOUNT, error) {
+	if value.Sign() < 0 {
+		return "", newError(ERRINVALIDINPUT, "amount must not be negative")
+	}
+	if value.Cmp(maxAmount) > 0 {
+		return "", newError(ERRINVALIDINPUT, "amount overflow")
+	}
+
+	return toAmount(value), nil
+}
+
+// addAmounts returns a + b, failing on overflow or a negative result.
+func addAmounts(a AMOUNT, b AMOUNT) (AMOUNT, error) {
+	x, err := parseAmount(a)
+	if err != nil {
+		return "", err
+	}
+	y, err := parseAmount(b)
+	if err != nil {
+		return "", err
+	}
+
+	return checkedAmount(x.Add(x, y))
+}
+
+// subAmounts returns a - b, failing on a negative result.
+func subAmounts(a AMOUNT, b AMOUNT) (AMOUNT, error) {
+	x, err := parseAmount(a)
+	if err != nil {
+		return "", err
+	}
+	y, err := parseAmount(b)
+	if err != nil {
+		return "", err
+	}
+
+	return checkedAmount(x.Sub(x, y))
+}
+
+// compareAmounts returns -1, 0 or +1 as a is less than, equal to or greater than b.
+func compareAmounts(a AMOUNT, b AMOUNT) (int, error) {
+	x, err := parseAmount(a)
+	if err != nil {
+		return 0, err
+	}
+	y, err := parseAmount(b)
+	if err != nil {
+		return 0, err
+	}
+
+	return x.Cmp(y), nil
+}
+
+// isPositiveAmount reports whether a is a valid amount greater than zero.
+func isPositiveAmount(a AMOUNT) (bool, error) {
+	x, err := parseAmount(a)
+	if err != nil {
+		return false, err
+	}
+
+	return x.Sign() > 0, nil
+}
+
+// negateAmount returns -a for recording debits as signed deltas.
+func negateAmount(a AMOUNT) (AMOUNT, error) {
+	x, err := parseAmount(a)
+	if err != nil {
+		return "", err
+	}
+
+	return toAmount(x.Neg(x)), nil
+}
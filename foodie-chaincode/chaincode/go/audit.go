@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// SUPPLYAUDIT compares the recorded supply of a token with the balances held by its owners and with
+// the mint and burn records. NetMinted is MintedTotal minus BurnedTotal. Consistent is false when
+// Discrepancies lists any mismatch.
+type SUPPLYAUDIT struct {
+	ID             string   `json:"Id"`
+	AuditedAt      string   `json:"AuditedAt"`
+	RecordedSupply AMOUNT   `json:"RecordedSupply"`
+	OwnerTotal     AMOUNT   `json:"OwnerTotal"`
+	OwnerCount     int      `json:"OwnerCount"`
+	MintedTotal    AMOUNT   `json:"MintedTotal"`
+	BurnedTotal    AMOUNT   `json:"BurnedTotal"`
+	NetMinted      AMOUNT   `json:"NetMinted"`
+	Consistent     bool     `json:"Consistent"`
+	Discrepancies  []string `json:"Discrepancies"`
+}
+
+// AuditSupply checks the supply invariant of token id: the sum of all owner balances, the recorded
+// total supply and minted minus burned must agree. It only reads the ledger, so it should be
+// evaluated rather than submitted. Only an Admin or an Auditor can run the audit.
+func (s *SmartContract) AuditSupply(ctx contractapi.TransactionContextInterface, id string) (*SUPPLYAUDIT, error) {
+	_, err := assertCallerRole(ctx, ADMINROLE, AUDITORROLE)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = getRegisteredToken(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	audit := &SUPPLYAUDIT{ID: id, Discrepancies: []string{}}
+	audit.AuditedAt, err = getRecordTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	audit.RecordedSupply, err = getTotalSupply(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	recorded, err := parseAmount(audit.RecordedSupply)
+	if err != nil {
+		return nil, err
+	}
+
+	// Sum every balance of the token, whichever model holds it
+	owners := make(map[string]bool)
+	ownerTotal, err := sumOwnerEntries(ctx, id, owners)
+	if err != nil {
+		return nil, err
+	}
+	audit.OwnerTotal = toAmount(ownerTotal)
+	audit.OwnerCount = len(owners)
+
+	// Sum the mint and burn records of the token
+	minted, burned, err := sumMintsAndBurns(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	audit.MintedTotal = toAmount(minted)
+	audit.BurnedTotal = toAmount(burned)
+	netMinted := new(big.Int).Sub(minted, burned)
+	audit.NetMinted = toAmount(netMinted)
+
+	if ownerTotal.Cmp(recorded) != 0 {
+		audit.Discrepancies = append(audit.Discrepancies, fmt.Sprintf("owner balances total %s but recorded supply is %s", audit.OwnerTotal, audit.RecordedSupply))
+	}
+	if netMinted.Cmp(recorded) != 0 {
+		audit.Discrepancies = append(audit.Discrepancies, fmt.Sprintf("minted minus burned is %s but recorded supply is %s", audit.NetMinted, audit.RecordedSupply))
+	}
+	if netMinted.Cmp(ownerTotal) != 0 {
+		audit.Discrepancies = append(audit.Discrepancies, fmt.Sprintf("minted minus burned is %s but owner balances total %s", audit.NetMinted, audit.OwnerTotal))
+	}
+	audit.Consistent = len(audit.Discrepancies) == 0
+
+	return audit, nil
+}
+
+// sumOwnerEntries adds up the owner entries, unconsolidated balance deltas and unspent outputs of
+// token id, recording every owner seen in owners.
+func sumOwnerEntries(ctx contractapi.TransactionContextInterface, id string, owners map[string]bool) (*big.Int, error) {
+	total := new(big.Int)
+
+	ownerIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(DOCTYPE+"~Owner", []string{id})
+	if err != nil {
+		return nil, err
+	}
+	defer ownerIterator.Close()
+
+	for ownerIterator.HasNext() {
+		queryResult, err := ownerIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var owner OWNERSTRUCT
+		err = json.Unmarshal(queryResult.Value, &owner)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal owner entry %s: %w", queryResult.Key, err)
+		}
+
+		amount, err := parseAmount(owner.Amount)
+		if err != nil {
+			return nil, err
+		}
+		total.Add(total, amount)
+		owners[owner.UserID] = true
+	}
+
+	deltaIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(BALANCEDELTA, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	defer deltaIterator.Close()
+
+	for deltaIterator.HasNext() {
+		queryResult, err := deltaIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var delta DELTASTRUCT
+		err = json.Unmarshal(queryResult.Value, &delta)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal delta %s: %w", queryResult.Key, err)
+		}
+
+		amount, err := parseAmount(delta.Amount)
+		if err != nil {
+			return nil, err
+		}
+		total.Add(total, amount)
+		owners[delta.UserID] = true
+	}
+
+	outputIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(UTXOINDEX, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	defer outputIterator.Close()
+
+	for outputIterator.HasNext() {
+		queryResult, err := outputIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var output UTXOSTRUCT
+		err = json.Unmarshal(queryResult.Value, &output)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal output %s: %w", queryResult.Key, err)
+		}
+
+		amount, err := parseAmount(output.Amount)
+		if err != nil {
+			return nil, err
+		}
+		total.Add(total, amount)
+		owners[output.Owner] = true
+	}
+
+	return total, nil
+}
+
+// sumMintsAndBurns adds up the MINTTX and BURNTXN records of token id through the supply
+// transaction index.
+func sumMintsAndBurns(ctx contractapi.TransactionContextInterface, id string) (*big.Int, *big.Int, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(SUPPLYTXNINDEX, []string{id})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resultsIterator.Close()
+
+	minted := new(big.Int)
+	burned := new(big.Int)
+	for resultsIterator.HasNext() {
+		queryResult, err := resultsIterator.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// The index entry points at the transaction record
+		recordAsByte, err := ctx.GetStub().GetState(string(queryResult.Value))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to fetch transaction record: %w", err)
+		}
+		if recordAsByte == nil {
+			return nil, nil, fmt.Errorf("transaction record for index entry %s does not exist", queryResult.Key)
+		}
+
+		var record LEDGERRECORD
+		err = json.Unmarshal(recordAsByte, &record)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to unmarshal transaction record %s: %w", queryResult.Value, err)
+		}
+
+		switch record.DocType {
+		case MINTTXN:
+			amount, err := parseAmount(record.Amount)
+			if err != nil {
+				return nil, nil, err
+			}
+			minted.Add(minted, amount)
+		case BURN:
+			amount, err := parseAmount(record.BurnTokenAmount)
+			if err != nil {
+				return nil, nil, err
+			}
+			burned.Add(burned, amount)
+		}
+	}
+
+	return minted, burned, nil
+}
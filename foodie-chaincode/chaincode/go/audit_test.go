@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func TestAuditSupply(t *testing.T) {
+	ledger := newBalanceTestLedger(t)
+	ledger.registerAccount("auditor", AUDITORROLE)
+	ledger.registerAccount("minter", MINTERROLE)
+	ledger.registerAccount("alice", STUDENTROLE, BURNERROLE)
+	ledger.registerAccount("bob", STUDENTROLE)
+
+	audit := func(caller string, id string) (*SUPPLYAUDIT, error) {
+		var result *SUPPLYAUDIT
+		err := ledger.runAs(newTestIdentity(caller), func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			result, err = new(SmartContract).AuditSupply(ctx, id)
+			return err
+		})
+		return result, err
+	}
+
+	tokens := []TOKENDEF{
+		{ID: "MEAL", IssuerOrg: "Org1MSP", Transferable: true, DocType: TOKEN},
+		{ID: "HOT", IssuerOrg: "Org1MSP", Transferable: true, DeltaWrites: true, DocType: TOKEN},
+		{ID: "CASH", IssuerOrg: "Org1MSP", Transferable: true, UTXO: true, DocType: TOKEN},
+	}
+	for _, token := range tokens {
+		ledger.createToken(token)
+		for i, amount := range []AMOUNT{"40", "20"} {
+			if _, err := ledger.mint("minter", FOODIE{TxnID: token.ID + "-m" + string(rune('1'+i)), ID: token.ID, UserId: "alice", Amount: amount}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		err := ledger.runAs(newTestIdentity("alice"), func(ctx contractapi.TransactionContextInterface) error {
+			_, err := new(SmartContract).TransferTyped(ctx, TRANSFER{TxnID: token.ID + "-t1", ID: token.ID, UserId: "alice", Receiver: "bob", Amount: "15"})
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ledger.runAs(newTestIdentity("alice"), func(ctx contractapi.TransactionContextInterface) error {
+			_, err := new(SmartContract).BurnTyped(ctx, BURNTOKEN{TxnID: token.ID + "-b1", ID: token.ID, BurnTokenID: "alice", BurnTokenAmount: "5"})
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := audit("auditor", token.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Consistent || len(result.Discrepancies) != 0 {
+			t.Fatalf("expected a consistent audit of %s, got %+v", token.ID, result)
+		}
+		if result.RecordedSupply != "55" || result.OwnerTotal != "55" || result.OwnerCount != 2 || result.MintedTotal != "60" || result.BurnedTotal != "5" || result.NetMinted != "55" {
+			t.Fatalf("unexpected audit of %s: %+v", token.ID, result)
+		}
+	}
+
+	// Only an Admin or an Auditor can run the audit
+	_, err := audit("alice", "MEAL")
+	assertErrorCode(t, err, ERRUNAUTHORIZED)
+
+	// A corrupted owner entry shows up as a discrepancy against the supply and the mint records
+	ownerKey, err := ledger.stub.CreateCompositeKey(DOCTYPE+"~Owner", []string{"MEAL", "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var owner OWNERSTRUCT
+	if err := json.Unmarshal(ledger.stub.State[ownerKey], &owner); err != nil {
+		t.Fatal(err)
+	}
+	owner.Amount = "1015"
+	ownerAsByte, err := json.Marshal(owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ledger.stub.State[ownerKey] = ownerAsByte
+
+	result, err := audit("auditor", "MEAL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Consistent || len(result.Discrepancies) != 2 || result.OwnerTotal != "1055" || result.NetMinted != "55" {
+		t.Fatalf("expected the corrupted owner entry to be reported, got %+v", result)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// balanceTestLedger runs balance helpers against a mock stub, one mock transaction per call.
+type balanceTestLedger struct {
+	t    *testing.T
+	stub *shimtest.MockStub
+	txns int
+}
+
+func newBalanceTestLedger(t *testing.T) *balanceTestLedger {
+	return &balanceTestLedger{t: t, stub: shimtest.NewMockStub("foodie", nil)}
+}
+
+func (l *balanceTestLedger) run(fn func(ctx contractapi.TransactionContextInterface) error) error {
+	l.txns++
+	txId := fmt.Sprintf("tx%d", l.txns)
+	l.stub.MockTransactionStart(txId)
+	defer l.stub.MockTransactionEnd(txId)
+
+	ctx := &contractapi.TransactionContext{}
+	ctx.SetStub(l.stub)
+	return fn(ctx)
+}
+
+func (l *balanceTestLedger) createToken(token TOKENDEF) {
+	l.t.Helper()
+	err := l.run(func(ctx contractapi.TransactionContextInterface) error {
+		tokenKey, err := ctx.GetStub().CreateCompositeKey(DOCTYPE+"~Token", []string{token.ID})
+		if err != nil {
+			return err
+		}
+		tokenAsByte, err := json.Marshal(token)
+		if err != nil {
+			return err
+		}
+		return ctx.GetStub().PutState(tokenKey, tokenAsByte)
+	})
+	if err != nil {
+		l.t.Fatal(err)
+	}
+}
+
+func (l *balanceTestLedger) add(userId string, id string, amount AMOUNT) error {
+	return l.run(func(ctx contractapi.TransactionContextInterface) error {
+		return addBalance(ctx, userId, id, amount)
+	})
+}
+
+func (l *balanceTestLedger) remove(userId string, id string, amount AMOUNT) error {
+	return l.run(func(ctx contractapi.TransactionContextInterface) error {
+		return removeBalance(ctx, userId, id, amount)
+	})
+}
+
+func (l *balanceTestLedger) balance(userId string, id string) AMOUNT {
+	l.t.Helper()
+	var balance AMOUNT
+	err := l.run(func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		balance, err = getOwnerBalance(ctx, userId, id)
+		return err
+	})
+	if err != nil {
+		l.t.Fatal(err)
+	}
+	return balance
+}
+
+func (l *balanceTestLedger) ownerEntryExists(userId string, id string) bool {
+	l.t.Helper()
+	ownerKey, err := l.stub.CreateCompositeKey(DOCTYPE+"~Owner", []string{id, userId})
+	if err != nil {
+		l.t.Fatal(err)
+	}
+	return l.stub.State[ownerKey] != nil
+}
+
+func assertInsufficientFunds(t *testing.T, err error, balance AMOUNT) {
+	t.Helper()
+	var insufficient *InsufficientFundsError
+	if !errors.As(err, &insufficient) {
+		t.Fatalf("expected InsufficientFundsError, got %v", err)
+	}
+	if insufficient.Balance != balance {
+		t.Fatalf("expected reported balance %s, got %s", balance, insufficient.Balance)
+	}
+}
+
+func TestRemoveBalanceFromMissingOwnerFails(t *testing.T) {
+	ledger := newBalanceTestLedger(t)
+
+	err := ledger.remove("alice", "MEAL", "10")
+	assertInsufficientFunds(t, err, "0")
+
+	if ledger.ownerEntryExists("alice", "MEAL") {
+		t.Fatal("a failed debit must not create an owner entry")
+	}
+	if balance := ledger.balance("alice", "MEAL"); balance != "0" {
+		t.Fatalf("expected balance 0, got %s", balance)
+	}
+}
+
+func TestRemoveBalanceFromZeroBalanceFails(t *testing.T) {
+	ledger := newBalanceTestLedger(t)
+
+	if err := ledger.add("alice", "MEAL", "10"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ledger.remove("alice", "MEAL", "10"); err != nil {
+		t.Fatal(err)
+	}
+
+	err := ledger.remove("alice", "MEAL", "1")
+	assertInsufficientFunds(t, err, "0")
+}
+
+func TestRemoveBalanceMoreThanBalanceFails(t *testing.T) {
+	ledger := newBalanceTestLedger(t)
+
+	if err := ledger.add("alice", "MEAL", "10"); err != nil {
+		t.Fatal(err)
+	}
+
+	err := ledger.remove("alice", "MEAL", "11")
+	assertInsufficientFunds(t, err, "10")
+
+	if balance := ledger.balance("alice", "MEAL"); balance != "10" {
+		t.Fatalf("expected balance 10, got %s", balance)
+	}
+}
+
+func TestRemoveBalanceRejectsNonPositiveAmounts(t *testing.T) {
+	ledger := newBalanceTestLedger(t)
+
+	for _, amount := range []AMOUNT{"0", "-5"} {
+		if err := ledger.remove("alice", "MEAL", amount); err == nil {
+			t.Fatalf("expected debit of %s to fail", amount)
+		}
+	}
+	if ledger.ownerEntryExists("alice", "MEAL") {
+		t.Fatal("a failed debit must not create an owner entry")
+	}
+}
+
+func TestAddAndRemoveBalance(t *testing.T) {
+	ledger := newBalanceTestLedger(t)
+
+	if err := ledger.add("alice", "MEAL", "10"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ledger.add("alice", "MEAL", "5"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ledger.remove("alice", "MEAL", "15"); err != nil {
+		t.Fatal(err)
+	}
+
+	if balance := ledger.balance("alice", "MEAL"); balance != "0" {
+		t.Fatalf("expected balance 0, got %s", balance)
+	}
+	if balance := ledger.balance("bob", "MEAL"); balance != "0" {
+		t.Fatalf("expected balance 0 for an unknown owner, got %s", balance)
+	}
+}
+
+func TestAddBalanceRejectsOverflow(t *testing.T) {
+	ledger := newBalanceTestLedger(t)
+
+	if err := ledger.add("alice", "MEAL", toAmount(maxAmount)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ledger.add("alice", "MEAL", "1"); err == nil {
+		t.Fatal("expected overflow to fail")
+	}
+
+	if balance := ledger.balance("alice", "MEAL"); balance != toAmount(maxAmount) {
+		t.Fatalf("expected balance to be unchanged, got %s", balance)
+	}
+}
+
+func TestRemoveBalanceFromMissingOwnerFailsForDeltaAndUTXOTokens(t *testing.T) {
+	ledger := newBalanceTestLedger(t)
+	ledger.createToken(TOKENDEF{ID: "HOT", DeltaWrites: true, DocType: TOKEN})
+	ledger.createToken(TOKENDEF{ID: "COIN", UTXO: true, DocType: TOKEN})
+
+	for _, id := range []string{"HOT", "COIN"} {
+		err := ledger.remove("alice", id, "1")
+		assertInsufficientFunds(t, err, "0")
+
+		if err := ledger.add("alice", id, "10"); err != nil {
+			t.Fatal(err)
+		}
+		err = ledger.remove("alice", id, "11")
+		assertInsufficientFunds(t, err, "10")
+
+		if err := ledger.remove("alice", id, "4"); err != nil {
+			t.Fatal(err)
+		}
+		if balance := ledger.balance("alice", id); balance != "6" {
+			t.Fatalf("%s: expected balance 6, got %s", id, balance)
+		}
+	}
+}
+
+func TestFrozenAccountBalanceChanges(t *testing.T) {
+	ledger := newBalanceTestLedger(t)
+	freeze := func(userId string, id string, blockCredits bool) {
+		t.Helper()
+		err := ledger.run(func(ctx contractapi.TransactionContextInterface) error {
+			return putFreeze(ctx, &FREEZESTRUCT{UserID: userId, ID: id, Frozen: true, ReasonCode: LOSTCARDREASON, BlockCredits: blockCredits, DocType: FREEZE}, FREEZEEVENT)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertFrozen := func(err error) {
+		t.Helper()
+		if err == nil {
+			t.Fatal("expected the frozen account to be refused")
+		}
+		if decoded := decodeChaincodeError(t, err); decoded.Code != ERRFROZEN {
+			t.Fatalf("expected code %s, got %s", ERRFROZEN, decoded.Code)
+		}
+	}
+
+	if err := ledger.add("alice", "MEAL", "10"); err != nil {
+		t.Fatal(err)
+	}
+	freeze("alice", "", false)
+	assertFrozen(ledger.remove("alice", "MEAL", "1"))
+	if err := ledger.add("alice", "MEAL", "5"); err != nil {
+		t.Fatal(err)
+	}
+
+	freeze("bob", "MEAL", true)
+	assertFrozen(ledger.add("bob", "MEAL", "5"))
+	if err := ledger.add("bob", "SNACK", "5"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ledger.remove("bob", "SNACK", "5"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDecreaseSupplyWithoutSupplyRecordIsNotFound(t *testing.T) {
+	ledger := newBalanceTestLedger(t)
+
+	err := ledger.run(func(ctx contractapi.TransactionContextInterface) error {
+		return decreaseSupply(ctx, "MEAL", "1")
+	})
+	assertErrorCode(t, err, ERRNOTFOUND)
+}
@@ -0,0 +1,565 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// TRANSFERLEG is one receiver of a TransferBatch. Legs may use different token ids.
+type TRANSFERLEG struct {
+	Receiver string `json:"Receiver"`
+	ID       string `json:"Id"`
+	Amount   AMOUNT `json:"Amount"`
+}
+
+type TRANSFERBATCH struct {
+	TxnID   string        `json:"TxnId"`
+	UserId  string        `json:"UserId"`
+	DocType string        `json:"DocType"`
+	Legs    []TRANSFERLEG `json:"Legs"`
+}
+
+// MINTROW is one roster entry of a MintBatch.
+type MINTROW struct {
+	UserId string `json:"UserId"`
+	Amount AMOUNT `json:"Amount"`
+}
+
+// MINTBATCH is the MintBatch payload. ExpiresAt is the expiry of the lots minted of an Expiring
+// token. Rows hold MINTROWs and are decoded one at a time, so a malformed row is rejected on its
+// own instead of failing the batch.
+type MINTBATCH struct {
+	TxnID     string            `json:"TxnId"`
+	ID        string            `json:"Id"`
+	DocType   string            `json:"DocType"`
+	ExpiresAt string            `json:"ExpiresAt"`
+	Rows      []json.RawMessage `json:"Rows"`
+}
+
+// REJECTEDROW is a roster entry MintBatch skipped. Row is one based.
+type REJECTEDROW struct {
+	Row    int    `json:"Row"`
+	UserId string `json:"UserId"`
+	Reason string `json:"Reason"`
+}
+
+type MINTBATCHSUMMARY struct {
+	TxnID       string        `json:"TxnId"`
+	ID          string        `json:"Id"`
+	Count       int           `json:"Count"`
+	TotalMinted AMOUNT        `json:"TotalMinted"`
+	Rejected    []REJECTEDROW `json:"Rejected"`
+}
+
+const TRANSFERBATCHFUNC = "TransferBatch"
+const MINTBATCHFUNC = "MintBatch"
+const MINTBATCHINDEX = DOCTYPE + "~MintBatch"
+
+// TransferBatch pays several receivers from the caller's account in one transaction. The sender
+// is debited once per token id for the total of its legs, each leg is recorded as its own
+// TRANSFERTXN under TxnId#<leg number>, and the whole batch fails if any leg is invalid. The
+// receipt of the batch is returned, also when an identical batch with the same TxnId was already
+// applied.
+func (s *SmartContract) TransferBatch(ctx contractapi.TransactionContextInterface, input string) (*RECEIPT, error) {
+	// Unmarshal the input JSON into a batch structure
+	var batchInput TRANSFERBATCH
+	err := decodeInput(input, &batchInput)
+	if err != nil {
+		return nil, err
+	}
+	err = validateTransferBatchInput(&batchInput)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("Unmarshaled input data:", batchInput)
+
+	// Ensure the caller is allowed to spend and owns the account being debited
+	_, err = assertCallerRole(ctx, STUDENTROLE, MERCHANTROLE)
+	if err != nil {
+		return nil, err
+	}
+
+	err = assertAccountOwner(ctx, batchInput.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	// Return the original receipt if this batch was already applied
+	receipt, requestHash, err := checkReceipt(ctx, batchInput.TxnID, TRANSFERBATCHFUNC, &batchInput)
+	if err != nil {
+		return nil, err
+	}
+	if receipt != nil {
+		return receipt, nil
+	}
+
+	// Validate every leg before touching any balance
+	indexName := "TxnID~" + DOCTYPE
+	tokens := make(map[string]*TOKENDEF)
+	debits := make(map[string]AMOUNT)
+	credits := make(map[string]map[string]AMOUNT)
+	txnKeys := make([]string, len(batchInput.Legs))
+	for i, leg := range batchInput.Legs {
+		positive, err := isPositiveAmount(leg.Amount)
+		if err != nil {
+			return nil, wrapError(err, ERRINTERNAL, "leg %d", i+1)
+		}
+		if !positive {
+			return nil, newError(ERRINVALIDINPUT, "leg %d: transfer amount must be greater than zero", i+1)
+		}
+		if leg.Receiver == "" || leg.Receiver == batchInput.UserId {
+			return nil, newError(ERRINVALIDINPUT, "leg %d: receiver must be another account", i+1)
+		}
+		err = assertAccountRegistered(ctx, leg.Receiver)
+		if err != nil {
+			return nil, wrapError(err, ERRINTERNAL, "leg %d", i+1)
+		}
+
+		if tokens[leg.ID] == nil {
+			token, err := getRegisteredToken(ctx, leg.ID)
+			if err != nil {
+				return nil, wrapError(err, ERRINTERNAL, "leg %d", i+1)
+			}
+			if !token.Transferable {
+				return nil, newError(ERRINVALIDINPUT, "leg %d: token %s is not transferable", i+1, token.ID)
+			}
+			err = assertNotPaused(ctx, token.ID)
+			if err != nil {
+				return nil, err
+			}
+			tokens[leg.ID] = token
+			credits[leg.ID] = make(map[string]AMOUNT)
+		}
+
+		// Check for duplicate transactions
+		TxnCompositeKey, err := ctx.GetStub().CreateCompositeKey(indexName, []string{legTxnID(batchInput.TxnID, i), leg.ID})
+		if err != nil {
+			return nil, err
+		}
+
+		checkTxnDuplication, err := ctx.GetStub().GetState(TxnCompositeKey)
+		if err != nil {
+			return nil, fmt.Errorf("error checking transaction duplication: %w", err)
+		}
+		if checkTxnDuplication != nil {
+			return nil, newError(ERRDUPLICATE, "duplicate transaction")
+		}
+
+		txnKeys[i] = TxnCompositeKey
+		debits[leg.ID], err = addAmounts(debits[leg.ID], leg.Amount)
+		if err != nil {
+			return nil, wrapError(err, ERRINTERNAL, "leg %d", i+1)
+		}
+		credits[leg.ID][leg.Receiver], err = addAmounts(credits[leg.ID][leg.Receiver], leg.Amount)
+		if err != nil {
+			return nil, wrapError(err, ERRINTERNAL, "leg %d", i+1)
+		}
+	}
+
+	// Debit the sender once per token id and credit each receiver once per token id, in a fixed
+	// order so every endorser produces the same write set. The earliest-expiring lots of an
+	// Expiring token are handed out in the same order.
+	for _, id := range sortedKeys(debits) {
+		err = removeBalance(ctx, batchInput.UserId, id, debits[id])
+		if err != nil {
+			return nil, err
+		}
+
+		lots, err := spendLots(ctx, batchInput.UserId, id, debits[id], false)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, receiver := range sortedKeys(credits[id]) {
+			err = addBalance(ctx, receiver, id, credits[id][receiver])
+			if err != nil {
+				return nil, err
+			}
+
+			var received []*LOTSTRUCT
+			received, lots, err = splitLots(lots, credits[id][receiver])
+			if err != nil {
+				return nil, err
+			}
+			err = creditLots(ctx, receiver, received)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Record one transfer per leg
+	timestamp, err := getRecordTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	legs := make([]EVENTLEG, len(batchInput.Legs))
+	for i, leg := range batchInput.Legs {
+		var txn TRANSFER
+		txn.DocType = TRANSFERTXN
+		txn.ID = leg.ID
+		txn.Amount = leg.Amount
+		txn.TxnID = legTxnID(batchInput.TxnID, i)
+		txn.Receiver = leg.Receiver
+		txn.UserId = batchInput.UserId
+		txn.BatchID = batchInput.TxnID
+		txn.Timestamp = timestamp
+
+		TXNAsByte, err := json.Marshal(txn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal transfer: %w", err)
+		}
+
+		err = ctx.GetStub().PutState(txnKeys[i], TXNAsByte)
+		if err != nil {
+			return nil, fmt.Errorf("failed to store composite key state: %v", err)
+		}
+
+		err = indexTxn(ctx, txnKeys[i], txn.ID, txn.UserId, txn.Receiver, txn.Timestamp, txn.TxnID)
+		if err != nil {
+			return nil, err
+		}
+
+		legs[i] = EVENTLEG{ID: leg.ID, From: batchInput.UserId, To: leg.Receiver, Amount: leg.Amount}
+	}
+
+	// Emit a single transfer event covering every leg
+	err = emitEvent(ctx, TRANSFEREVENT, batchInput.TxnID, legs)
+	if err != nil {
+		return nil, err
+	}
+
+	return putReceipt(ctx, batchInput.TxnID, TRANSFERBATCHFUNC, requestHash, legs)
+}
+
+// MintBatch mints token Id to every user of a roster. Invalid rows are skipped and reported in the
+// returned summary instead of failing the batch; the supply record is updated once and each
+// minted row is recorded as its own MINTTX under TxnId#<row number>. The summary is stored with
+// the receipt of the batch and returned again for an identical batch with the same TxnId.
+func (s *SmartContract) MintBatch(ctx contractapi.TransactionContextInterface, input string) (*MINTBATCHSUMMARY, error) {
+	// Unmarshal the input JSON into a batch structure
+	var batchInput MINTBATCH
+	err := decodeInput(input, &batchInput)
+	if err != nil {
+		return nil, err
+	}
+	err = validateMintBatchInput(&batchInput)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("Unmarshaled input data:", batchInput.TxnID, batchInput.ID, len(batchInput.Rows))
+
+	// Ensure only a Minter can mint tokens
+	minter, err := assertCallerRole(ctx, MINTERROLE)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("Minter ID:", minter)
+
+	// Return the original summary if this batch was already applied
+	receipt, requestHash, err := checkReceipt(ctx, batchInput.TxnID, MINTBATCHFUNC, &batchInput)
+	if err != nil {
+		return nil, err
+	}
+	if receipt != nil {
+		return getMintBatchSummary(ctx, batchInput.TxnID)
+	}
+
+	// Ensure the token is registered and the caller's org is its issuer
+	token, err := getRegisteredToken(ctx, batchInput.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	clientMSPID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get MSPID: %w", err)
+	}
+	if clientMSPID != token.IssuerOrg {
+		return nil, newError(ERRUNAUTHORIZED, "client org %s is not the issuer of token %s", clientMSPID, token.ID)
+	}
+
+	// Refuse the batch while the contract or the token is paused
+	err = assertNotPaused(ctx, token.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Refuse mints outside the token's validity window and settle the expiry of the minted lots
+	err = assertTokenValid(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	expiresAt, err := lotExpiry(ctx, token, batchInput.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	// Retrieve the current total supply
+	var currFoodie FOODIE
+	forTotalSupply, err := ctx.GetStub().GetState(batchInput.ID)
+	if err != nil {
+		return nil, err
+	}
+	if forTotalSupply != nil {
+		err = json.Unmarshal(forTotalSupply, &currFoodie)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal total supply: %w", err)
+		}
+	}
+	currFoodie.ID = batchInput.ID
+	currFoodie.DocType = DOCTYPE
+
+	// Delta-write tokens only need the current supply to enforce a cap
+	currentSupply := currFoodie.TotalSupply
+	if token.DeltaWrites && hasMaxSupply(token) {
+		currentSupply, err = getTotalSupply(ctx, batchInput.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	summary := &MINTBATCHSUMMARY{TxnID: batchInput.TxnID, ID: batchInput.ID, TotalMinted: "0", Rejected: []REJECTEDROW{}}
+	reject := func(row int, userId string, reason string) {
+		summary.Rejected = append(summary.Rejected, REJECTEDROW{Row: row + 1, UserId: userId, Reason: reason})
+	}
+
+	timestamp, err := getRecordTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Validate the roster, keeping every row that can be minted
+	indexName := "TxnID~" + DOCTYPE
+	credits := make(map[string]AMOUNT)
+	var legs []EVENTLEG
+	var txns []TXN
+	var txnKeys []string
+	for i, rowInput := range batchInput.Rows {
+		var row MINTROW
+		err = decodeInput(string(rowInput), &row)
+		if err != nil {
+			// Still report whose row it was when only another field is malformed
+			var rowUser struct {
+				UserId string `json:"UserId"`
+			}
+			_ = json.Unmarshal(rowInput, &rowUser)
+			reject(i, rowUser.UserId, errorMessage(err))
+			continue
+		}
+
+		var v inputValidator
+		v.requireID("UserId", row.UserId)
+		err = v.err()
+		if err != nil {
+			reject(i, row.UserId, errorMessage(err))
+			continue
+		}
+		positive, err := isPositiveAmount(row.Amount)
+		if err != nil {
+			reject(i, row.UserId, errorMessage(err))
+			continue
+		}
+		if !positive {
+			reject(i, row.UserId, "mint amount must be greater than zero")
+			continue
+		}
+		err = assertAccountRegistered(ctx, row.UserId)
+		if err != nil {
+			reject(i, row.UserId, errorMessage(err))
+			continue
+		}
+		err = assertNotFrozen(ctx, row.UserId, batchInput.ID, true)
+		if err != nil {
+			reject(i, row.UserId, errorMessage(err))
+			continue
+		}
+		totalMinted, err := addAmounts(summary.TotalMinted, row.Amount)
+		if err != nil {
+			reject(i, row.UserId, errorMessage(err))
+			continue
+		}
+		newSupply, err := addAmounts(currentSupply, totalMinted)
+		if err != nil {
+			reject(i, row.UserId, errorMessage(err))
+			continue
+		}
+		exceeded, err := exceedsMaxSupply(token, newSupply)
+		if err != nil {
+			return nil, err
+		}
+		if exceeded {
+			reject(i, row.UserId, fmt.Sprintf("mint would exceed max supply %s", token.MaxSupply))
+			continue
+		}
+
+		var txn TXN
+		txn.ID = batchInput.ID
+		txn.UserID = row.UserId
+		txn.Amount = row.Amount
+		txn.DocType = MINTTXN
+		txn.TxnID = legTxnID(batchInput.TxnID, i)
+		txn.Timestamp = timestamp
+
+		// Check for duplicate transactions
+		TxnCompositeKey, err := ctx.GetStub().CreateCompositeKey(indexName, []string{txn.TxnID, txn.ID})
+		if err != nil {
+			return nil, err
+		}
+
+		checkTxnDuplication, err := ctx.GetStub().GetState(TxnCompositeKey)
+		if err != nil {
+			return nil, fmt.Errorf("error checking transaction duplication: %w", err)
+		}
+		if checkTxnDuplication != nil {
+			reject(i, row.UserId, "duplicate transaction")
+			continue
+		}
+
+		credits[row.UserId], err = addAmounts(credits[row.UserId], row.Amount)
+		if err != nil {
+			return nil, err
+		}
+		summary.Count++
+		summary.TotalMinted = totalMinted
+		txns = append(txns, txn)
+		txnKeys = append(txnKeys, TxnCompositeKey)
+		legs = append(legs, EVENTLEG{ID: txn.ID, To: txn.UserID, Amount: txn.Amount})
+	}
+
+	if summary.Count == 0 {
+		return putMintBatchSummary(ctx, summary, requestHash, legs)
+	}
+
+	// Credit each user once, in a fixed order so every endorser produces the same write set
+	for _, userId := range sortedKeys(credits) {
+		err = addBalance(ctx, userId, batchInput.ID, credits[userId])
+		if err != nil {
+			return nil, err
+		}
+
+		if token.Expiring {
+			err = creditLots(ctx, userId, []*LOTSTRUCT{{ID: token.ID, ExpiresAt: expiresAt, TxnID: batchInput.TxnID, Amount: credits[userId]}})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Update the total supply once for the whole roster
+	if token.DeltaWrites {
+		err = putSupplyDelta(ctx, batchInput.ID, summary.TotalMinted)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		currFoodie.TotalSupply, err = addAmounts(currFoodie.TotalSupply, summary.TotalMinted)
+		if err != nil {
+			return nil, wrapError(err, ERRINTERNAL, "failed to update total supply")
+		}
+
+		foodieAsByte, err := json.Marshal(currFoodie)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal foodie state: %w", err)
+		}
+
+		err = ctx.GetStub().PutState(currFoodie.ID, foodieAsByte)
+		if err != nil {
+			return nil, fmt.Errorf("failed to store foodie state: %v", err)
+		}
+	}
+
+	// Record one mint per accepted row
+	for i, txn := range txns {
+		TXNAsByte, err := json.Marshal(txn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal transaction: %w", err)
+		}
+
+		err = ctx.GetStub().PutState(txnKeys[i], TXNAsByte)
+		if err != nil {
+			return nil, fmt.Errorf("failed to store transaction state: %v", err)
+		}
+
+		err = indexTxn(ctx, txnKeys[i], txn.ID, "", txn.UserID, txn.Timestamp, txn.TxnID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Emit a single mint event covering every accepted row
+	err = emitEvent(ctx, MINTEVENT, batchInput.TxnID, legs)
+	if err != nil {
+		return nil, err
+	}
+
+	return putMintBatchSummary(ctx, summary, requestHash, legs)
+}
+
+// putMintBatchSummary stores the summary of a MintBatch next to its receipt.
+func putMintBatchSummary(ctx contractapi.TransactionContextInterface, summary *MINTBATCHSUMMARY, requestHash string, legs []EVENTLEG) (*MINTBATCHSUMMARY, error) {
+	_, err := putReceipt(ctx, summary.TxnID, MINTBATCHFUNC, requestHash, legs)
+	if err != nil {
+		return nil, err
+	}
+
+	summaryKey, err := ctx.GetStub().CreateCompositeKey(MINTBATCHINDEX, []string{summary.TxnID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key for mint batch summary: %w", err)
+	}
+
+	summaryAsByte, err := json.Marshal(summary)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal mint batch summary: %w", err)
+	}
+
+	err = ctx.GetStub().PutState(summaryKey, summaryAsByte)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store mint batch summary state: %v", err)
+	}
+
+	return summary, nil
+}
+
+// getMintBatchSummary reads the summary stored for the MintBatch of txnId.
+func getMintBatchSummary(ctx contractapi.TransactionContextInterface, txnId string) (*MINTBATCHSUMMARY, error) {
+	summaryKey, err := ctx.GetStub().CreateCompositeKey(MINTBATCHINDEX, []string{txnId})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key for mint batch summary: %w", err)
+	}
+
+	summaryAsByte, err := ctx.GetStub().GetState(summaryKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch mint batch summary: %w", err)
+	}
+	if summaryAsByte == nil {
+		return nil, newError(ERRNOTFOUND, "no summary for mint batch %s", txnId).withDetail("txnId", txnId)
+	}
+
+	var summary MINTBATCHSUMMARY
+	err = json.Unmarshal(summaryAsByte, &summary)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal mint batch summary: %w", err)
+	}
+
+	return &summary, nil
+}
+
+// legTxnID is the TxnId recorded for the i-th (zero based) entry of a batch. The # separator is
+// not allowed in client TxnIds, so leg ids never collide with the TxnId of another request.
+func legTxnID(txnId string, i int) string {
+	return fmt.Sprintf("%s#%d", txnId, i+1)
+}
+
+func sortedKeys(m map[string]AMOUNT) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
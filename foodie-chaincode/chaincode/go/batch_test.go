@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func TestMintBatchRejectsMalformedRowsAndMintsTheRest(t *testing.T) {
+	ledger := newBalanceTestLedger(t)
+	ledger.createToken(TOKENDEF{ID: "MEAL", IssuerOrg: "Org1MSP", Transferable: true, DocType: TOKEN})
+	ledger.registerAccount("minter", MINTERROLE)
+	ledger.registerAccount("alice", STUDENTROLE)
+	ledger.registerAccount("bob", STUDENTROLE)
+	ledger.registerAccount("carol", STUDENTROLE)
+	ledger.registerAccount("dave", STUDENTROLE)
+
+	var summary *MINTBATCHSUMMARY
+	err := ledger.runAs(newTestIdentity("minter"), func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		summary, err = new(SmartContract).MintBatch(ctx, `{"TxnId": "roster-1", "Id": "MEAL", "Rows": [
+			{"UserId": "alice", "Amount": "10"},
+			{"UserId": "bob", "Amount": "1.5"},
+			{"UserId": "carol", "Amount": "abc"},
+			{"UserId": "dave", "Amount": "5", "Note": "late"},
+			{"UserId": "carol", "Amount": 7}
+		]}`)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.Count != 2 || summary.TotalMinted != "17" {
+		t.Fatalf("expected 2 rows minting 17, got %+v", summary)
+	}
+	if len(summary.Rejected) != 3 {
+		t.Fatalf("expected 3 rejected rows, got %+v", summary.Rejected)
+	}
+	for i, expected := range []REJECTEDROW{{Row: 2, UserId: "bob"}, {Row: 3, UserId: "carol"}, {Row: 4, UserId: "dave"}} {
+		rejected := summary.Rejected[i]
+		if rejected.Row != expected.Row || rejected.UserId != expected.UserId || rejected.Reason == "" {
+			t.Errorf("expected row %d of %s to be rejected, got %+v", expected.Row, expected.UserId, rejected)
+		}
+	}
+
+	for userId, expected := range map[string]AMOUNT{"alice": "10", "bob": "0", "carol": "7", "dave": "0"} {
+		if balance := ledger.balance(userId, "MEAL"); balance != expected {
+			t.Errorf("expected balance of %s to be %s, got %s", userId, expected, balance)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// DELTASTRUCT is a single balance or supply change written under its own key by tokens created with
+// DeltaWrites. Supply deltas have no UserId and debits have a negative Amount.
+type DELTASTRUCT struct {
+	ID      string `json:"Id"`
+	UserID  string `json:"UserId"`
+	TxID    string `json:"TxId"`
+	DocType string `json:"DocType"`
+	Amount  AMOUNT `json:"Amount"`
+}
+
+const DELTA = "DELTA"
+const BALANCEDELTA = DOCTYPE + "~Delta"
+const SUPPLYDELTA = DOCTYPE + "~SupplyDelta"
+
+// Consolidate folds the outstanding delta keys of a delta-write token into the owner entry of user,
+// or into the supply record when user is empty. Only the account owner or an Admin can consolidate
+// a balance; only an Admin can consolidate the supply.
+func (s *SmartContract) Consolidate(ctx contractapi.TransactionContextInterface, id string, user string) error {
+	err := assertAccountOwner(ctx, user)
+	if err != nil {
+		_, err = assertCallerRole(ctx, ADMINROLE)
+		if err != nil {
+			return err
+		}
+	}
+
+	token, err := getRegisteredToken(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !token.DeltaWrites {
+		return newError(ERRINVALIDINPUT, "token %s does not use delta writes", id)
+	}
+
+	if user == "" {
+		delta, err := sumDeltas(ctx, SUPPLYDELTA, []string{id}, true)
+		if err != nil {
+			return err
+		}
+
+		var currFoodie FOODIE
+		forTotalSupply, err := ctx.GetStub().GetState(id)
+		if err != nil {
+			return err
+		}
+		if forTotalSupply != nil {
+			err = json.Unmarshal(forTotalSupply, &currFoodie)
+			if err != nil {
+				return fmt.Errorf("failed to unmarshal total supply: %w", err)
+			}
+		}
+		currFoodie.ID = id
+		currFoodie.DocType = DOCTYPE
+		currFoodie.TotalSupply, err = applyDelta(currFoodie.TotalSupply, delta)
+		if err != nil {
+			return wrapError(err, ERRINTERNAL, "failed to update total supply")
+		}
+
+		foodieAsByte, err := json.Marshal(currFoodie)
+		if err != nil {
+			return fmt.Errorf("failed to marshal foodie state: %w", err)
+		}
+
+		err = ctx.GetStub().PutState(id, foodieAsByte)
+		if err != nil {
+			return fmt.Errorf("failed to store foodie state: %v", err)
+		}
+
+		return nil
+	}
+
+	delta, err := sumDeltas(ctx, BALANCEDELTA, []string{id, user}, true)
+	if err != nil {
+		return err
+	}
+
+	ownerKey, err := ctx.GetStub().CreateCompositeKey(DOCTYPE+"~Owner", []string{id, user})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key for owner entry: %w", err)
+	}
+
+	owner := OWNERSTRUCT{ID: id, UserID: user, DocType: OWNER}
+	checkOwnerEntry, err := ctx.GetStub().GetState(ownerKey)
+	if err != nil {
+		return fmt.Errorf("failed to fetch owner entry: %w", err)
+	}
+	if checkOwnerEntry != nil {
+		err = json.Unmarshal(checkOwnerEntry, &owner)
+		if err != nil {
+			return fmt.Errorf("failed to unmarshal existing owner entry: %w", err)
+		}
+	}
+	owner.Amount, err = applyDelta(owner.Amount, delta)
+	if err != nil {
+		return fmt.Errorf("failed to update balance of owner %s: %w", user, err)
+	}
+
+	OwnerAsByte, err := json.Marshal(owner)
+	if err != nil {
+		return fmt.Errorf("failed to marshal owner structure: %w", err)
+	}
+
+	err = ctx.GetStub().PutState(ownerKey, OwnerAsByte)
+	if err != nil {
+		return fmt.Errorf("failed to put owner state: %v", err)
+	}
+
+	return nil
+}
+
+// usesDeltaWrites reports whether balance and supply changes of token id go to delta keys.
+func usesDeltaWrites(ctx contractapi.TransactionContextInterface, id string) (bool, error) {
+	token, err := getToken(ctx, id)
+	if err != nil {
+		return false, err
+	}
+
+	return token != nil && token.DeltaWrites, nil
+}
+
+// putBalanceDelta records a balance change under a key unique to the transaction, so concurrent
+// transactions crediting the same account do not conflict. Callers must aggregate so that an
+// account receives at most one delta per token per transaction.
+func putBalanceDelta(ctx contractapi.TransactionContextInterface, userId string, id string, amount AMOUNT) error {
+	txId := ctx.GetStub().GetTxID()
+	deltaKey, err := ctx.GetStub().CreateCompositeKey(BALANCEDELTA, []string{id, userId, txId})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key for balance delta: %w", err)
+	}
+
+	return putDelta(ctx, deltaKey, DELTASTRUCT{ID: id, UserID: userId, TxID: txId, DocType: DELTA, Amount: amount})
+}
+
+// putSupplyDelta records a supply change under a key unique to the transaction.
+func putSupplyDelta(ctx contractapi.TransactionContextInterface, id string, amount AMOUNT) error {
+	txId := ctx.GetStub().GetTxID()
+	deltaKey, err := ctx.GetStub().CreateCompositeKey(SUPPLYDELTA, []string{id, txId})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key for supply delta: %w", err)
+	}
+
+	return putDelta(ctx, deltaKey, DELTASTRUCT{ID: id, TxID: txId, DocType: DELTA, Amount: amount})
+}
+
+func putDelta(ctx contractapi.TransactionContextInterface, deltaKey string, delta DELTASTRUCT) error {
+	deltaAsByte, err := json.Marshal(delta)
+	if err != nil {
+		return fmt.Errorf("failed to marshal delta: %w", err)
+	}
+
+	err = ctx.GetStub().PutState(deltaKey, deltaAsByte)
+	if err != nil {
+		return fmt.Errorf("failed to store delta state: %v", err)
+	}
+
+	return nil
+}
+
+// sumDeltas adds up the delta keys under the partial key, deleting them when consume is set.
+// The sum may be negative.
+func sumDeltas(ctx contractapi.TransactionContextInterface, indexName string, attributes []string, consume bool) (*big.Int, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(indexName, attributes)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	total := new(big.Int)
+	for resultsIterator.HasNext() {
+		queryResult, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var delta DELTASTRUCT
+		err = json.Unmarshal(queryResult.Value, &delta)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal delta: %w", err)
+		}
+
+		amount, err := parseAmount(delta.Amount)
+		if err != nil {
+			return nil, err
+		}
+		total.Add(total, amount)
+
+		if consume {
+			err = ctx.GetStub().DelState(queryResult.Key)
+			if err != nil {
+				return nil, fmt.Errorf("failed to delete delta state: %v", err)
+			}
+		}
+	}
+
+	return total, nil
+}
+
+// applyDelta adds a summed delta to a base amount, rejecting overflow and negative results.
+func applyDelta(base AMOUNT, delta *big.Int) (AMOUNT, error) {
+	value, err := parseAmount(base)
+	if err != nil {
+		return "", err
+	}
+
+	return checkedAmount(value.Add(value, delta))
+}
+
+// getOwnerBalance returns the balance of userId for token id, including unconsolidated deltas. The
+// balance of a UTXO token is the sum of its unspent outputs.
+func getOwnerBalance(ctx contractapi.TransactionContextInterface, userId string, id string) (AMOUNT, error) {
+	token, err := getToken(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if token != nil && token.UTXO {
+		return sumOutputs(ctx, userId, id)
+	}
+
+	ownerKey, err := ctx.GetStub().CreateCompositeKey(DOCTYPE+"~Owner", []string{id, userId})
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key for owner entry: %w", err)
+	}
+
+	checkOwnerEntry, err := ctx.GetStub().GetState(ownerKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch owner entry: %w", err)
+	}
+
+	checkOwner := OWNERSTRUCT{Amount: "0"}
+	if checkOwnerEntry != nil {
+		err = json.Unmarshal(checkOwnerEntry, &checkOwner)
+		if err != nil {
+			return "", fmt.Errorf("failed to unmarshal existing owner entry: %w", err)
+		}
+	}
+
+	if token == nil || !token.DeltaWrites {
+		return checkOwner.Amount, nil
+	}
+
+	delta, err := sumDeltas(ctx, BALANCEDELTA, []string{id, userId}, false)
+	if err != nil {
+		return "", err
+	}
+
+	return applyDelta(checkOwner.Amount, delta)
+}
+
+// getTotalSupply returns the supply of token id, including unconsolidated deltas.
+func getTotalSupply(ctx contractapi.TransactionContextInterface, id string) (AMOUNT, error) {
+	forTotalSupply, err := ctx.GetStub().GetState(id)
+	if err != nil {
+		return "", err
+	}
+
+	currFoodie := FOODIE{TotalSupply: "0"}
+	if forTotalSupply != nil {
+		err = json.Unmarshal(forTotalSupply, &currFoodie)
+		if err != nil {
+			return "", fmt.Errorf("failed to unmarshal total supply: %w", err)
+		}
+	}
+
+	deltaWrites, err := usesDeltaWrites(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if !deltaWrites {
+		return currFoodie.TotalSupply, nil
+	}
+
+	delta, err := sumDeltas(ctx, SUPPLYDELTA, []string{id}, false)
+	if err != nil {
+		return "", err
+	}
+
+	return applyDelta(currFoodie.TotalSupply, delta)
+}
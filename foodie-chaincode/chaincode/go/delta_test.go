@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// countKeys counts the keys under the partial composite key.
+func (l *balanceTestLedger) countKeys(indexName string, attributes ...string) int {
+	l.t.Helper()
+	count := 0
+	err := l.run(func(ctx contractapi.TransactionContextInterface) error {
+		resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(indexName, attributes)
+		if err != nil {
+			return err
+		}
+		defer resultsIterator.Close()
+		for resultsIterator.HasNext() {
+			_, err = resultsIterator.Next()
+			if err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		l.t.Fatal(err)
+	}
+	return count
+}
+
+func TestConsolidateFoldsDeltasWithoutChangingBalanceOrSupply(t *testing.T) {
+	ledger := newBalanceTestLedger(t)
+	ledger.createToken(TOKENDEF{ID: "HOT", IssuerOrg: "Org1MSP", Transferable: true, DeltaWrites: true, DocType: TOKEN})
+	ledger.registerAccount("admin", ADMINROLE)
+	ledger.registerAccount("minter", MINTERROLE)
+	ledger.registerAccount("alice", STUDENTROLE)
+	ledger.registerAccount("bob", STUDENTROLE)
+	for i, amount := range []AMOUNT{"30", "12"} {
+		if _, err := ledger.mint("minter", FOODIE{TxnID: "m" + string(rune('1'+i)), ID: "HOT", UserId: "alice", Amount: amount}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ledger.remove("alice", "HOT", "5"); err != nil {
+		t.Fatal(err)
+	}
+
+	supply := func() AMOUNT {
+		var supply AMOUNT
+		err := ledger.run(func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			supply, err = getTotalSupply(ctx, "HOT")
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return supply
+	}
+	consolidate := func(caller string, user string) error {
+		return ledger.runAs(newTestIdentity(caller), func(ctx contractapi.TransactionContextInterface) error {
+			return new(SmartContract).Consolidate(ctx, "HOT", user)
+		})
+	}
+
+	if balance := ledger.balance("alice", "HOT"); balance != "37" {
+		t.Fatalf("expected balance 37, got %s", balance)
+	}
+	if total := supply(); total != "42" {
+		t.Fatalf("expected supply 42, got %s", total)
+	}
+	if ledger.countKeys(BALANCEDELTA, "HOT", "alice") == 0 || ledger.countKeys(SUPPLYDELTA, "HOT") == 0 {
+		t.Fatal("expected outstanding delta keys")
+	}
+
+	// Only the owner or an Admin consolidates a balance, only an Admin the supply
+	assertErrorCode(t, consolidate("bob", "alice"), ERRUNAUTHORIZED)
+	assertErrorCode(t, consolidate("alice", ""), ERRUNAUTHORIZED)
+
+	if err := consolidate("alice", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := consolidate("admin", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if count := ledger.countKeys(BALANCEDELTA, "HOT", "alice"); count != 0 {
+		t.Fatalf("expected the balance deltas to be consumed, %d left", count)
+	}
+	if count := ledger.countKeys(SUPPLYDELTA, "HOT"); count != 0 {
+		t.Fatalf("expected the supply deltas to be consumed, %d left", count)
+	}
+	if balance := ledger.balance("alice", "HOT"); balance != "37" {
+		t.Fatalf("expected balance 37 after consolidating, got %s", balance)
+	}
+	if total := supply(); total != "42" {
+		t.Fatalf("expected supply 42 after consolidating, got %s", total)
+	}
+
+	// The folded amounts now live in the owner entry and the supply record
+	ownerKey, err := ledger.stub.CreateCompositeKey(DOCTYPE+"~Owner", []string{"HOT", "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var owner OWNERSTRUCT
+	if err := json.Unmarshal(ledger.stub.State[ownerKey], &owner); err != nil {
+		t.Fatal(err)
+	}
+	var record FOODIE
+	if err := json.Unmarshal(ledger.stub.State["HOT"], &record); err != nil {
+		t.Fatal(err)
+	}
+	if owner.Amount != "37" || record.TotalSupply != "42" {
+		t.Fatalf("expected owner entry 37 and supply record 42, got %s and %s", owner.Amount, record.TotalSupply)
+	}
+}
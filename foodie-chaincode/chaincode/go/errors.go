@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// CHAINCODEERROR is the error model returned to clients. It is serialized as JSON in the error
+// string so SDKs can map Code to a status without parsing the message. Errors that are not
+// CHAINCODEERRORs are unexpected ledger or encoding failures and should be treated as INTERNAL.
+type CHAINCODEERROR struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+const ERRUNAUTHORIZED = "UNAUTHORIZED"
+const ERRNOTFOUND = "NOT_FOUND"
+const ERRDUPLICATE = "DUPLICATE"
+const ERRCONFLICT = "CONFLICT"
+const ERRINSUFFICIENTFUNDS = "INSUFFICIENT_FUNDS"
+const ERRINVALIDINPUT = "INVALID_INPUT"
+const ERRPAUSED = "PAUSED"
+const ERRFROZEN = "FROZEN"
+const ERRINTERNAL = "INTERNAL"
+
+func (e *CHAINCODEERROR) Error() string {
+	errorAsByte, err := json.Marshal(e)
+	if err != nil {
+		return e.Message
+	}
+
+	return string(errorAsByte)
+}
+
+// newError returns a CHAINCODEERROR with code and a formatted message.
+func newError(code string, format string, args ...interface{}) *CHAINCODEERROR {
+	return &CHAINCODEERROR{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// withDetail adds a detail to e and returns it for chaining.
+func (e *CHAINCODEERROR) withDetail(key string, value string) *CHAINCODEERROR {
+	if e.Details == nil {
+		e.Details = make(map[string]string)
+	}
+	e.Details[key] = value
+	return e
+}
+
+// wrapError prefixes the message of err with context. The code and details of a typed error are
+// kept; any other error becomes a CHAINCODEERROR with code.
+func wrapError(err error, code string, format string, args ...interface{}) error {
+	prefix := fmt.Sprintf(format, args...)
+
+	var insufficient *InsufficientFundsError
+	if errors.As(err, &insufficient) {
+		err = insufficient.toChaincodeError()
+	}
+
+	var typed *CHAINCODEERROR
+	if errors.As(err, &typed) {
+		return &CHAINCODEERROR{Code: typed.Code, Message: prefix + ": " + typed.Message, Details: typed.Details}
+	}
+
+	return &CHAINCODEERROR{Code: code, Message: prefix + ": " + err.Error()}
+}
+
+// errorMessage returns the message of err without the JSON envelope of a typed error, for
+// embedding in results such as rejected batch rows.
+func errorMessage(err error) string {
+	var insufficient *InsufficientFundsError
+	if errors.As(err, &insufficient) {
+		return insufficient.toChaincodeError().Message
+	}
+
+	var typed *CHAINCODEERROR
+	if errors.As(err, &typed) {
+		return typed.Message
+	}
+
+	return err.Error()
+}
+
+// InsufficientFundsError is returned when a debit is larger than the balance of an owner, including
+// owners that have never held the token. It is reported to clients as INSUFFICIENT_FUNDS.
+type InsufficientFundsError struct {
+	UserID  string
+	ID      string
+	Balance AMOUNT
+	Amount  AMOUNT
+}
+
+func (e *InsufficientFundsError) Error() string {
+	return e.toChaincodeError().Error()
+}
+
+func (e *InsufficientFundsError) toChaincodeError() *CHAINCODEERROR {
+	return newError(ERRINSUFFICIENTFUNDS, "insufficient balance for owner %s: has %s of token %s, needs %s", e.UserID, e.Balance, e.ID, e.Amount).
+		withDetail("userId", e.UserID).
+		withDetail("id", e.ID).
+		withDetail("balance", string(e.Balance)).
+		withDetail("amount", string(e.Amount))
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func decodeChaincodeError(t *testing.T, err error) CHAINCODEERROR {
+	t.Helper()
+	var decoded CHAINCODEERROR
+	if jsonErr := json.Unmarshal([]byte(err.Error()), &decoded); jsonErr != nil {
+		t.Fatalf("expected a JSON error, got %q", err.Error())
+	}
+	return decoded
+}
+
+func TestInsufficientFundsErrorIsSerializedWithCode(t *testing.T) {
+	err := error(&InsufficientFundsError{UserID: "alice", ID: "MEAL", Balance: "3", Amount: "5"})
+
+	decoded := decodeChaincodeError(t, err)
+	if decoded.Code != ERRINSUFFICIENTFUNDS {
+		t.Fatalf("expected code %s, got %s", ERRINSUFFICIENTFUNDS, decoded.Code)
+	}
+	if decoded.Details["balance"] != "3" || decoded.Details["amount"] != "5" {
+		t.Fatalf("unexpected details %v", decoded.Details)
+	}
+}
+
+func TestWrapErrorKeepsCode(t *testing.T) {
+	err := wrapError(newError(ERRNOTFOUND, "token MEAL is not registered"), ERRINTERNAL, "leg %d", 2)
+
+	decoded := decodeChaincodeError(t, err)
+	if decoded.Code != ERRNOTFOUND {
+		t.Fatalf("expected code %s, got %s", ERRNOTFOUND, decoded.Code)
+	}
+	if decoded.Message != "leg 2: token MEAL is not registered" {
+		t.Fatalf("unexpected message %q", decoded.Message)
+	}
+
+	err = wrapError(&InsufficientFundsError{UserID: "alice", ID: "MEAL", Balance: "0", Amount: "1"}, ERRINTERNAL, "leg 1")
+	if decoded = decodeChaincodeError(t, err); decoded.Code != ERRINSUFFICIENTFUNDS {
+		t.Fatalf("expected code %s, got %s", ERRINSUFFICIENTFUNDS, decoded.Code)
+	}
+
+	err = wrapError(errors.New("ledger unavailable"), ERRINTERNAL, "failed to update total supply")
+	if decoded = decodeChaincodeError(t, err); decoded.Code != ERRINTERNAL {
+		t.Fatalf("expected code %s, got %s", ERRINTERNAL, decoded.Code)
+	}
+}
+
+func TestInvalidAmountInInputIsInvalidInput(t *testing.T) {
+	var transfer TRANSFER
+	err := json.Unmarshal([]byte(`{"Amount": "1.5"}`), &transfer)
+	if err == nil {
+		t.Fatal("expected a fractional amount to fail")
+	}
+
+	err = wrapError(err, ERRINVALIDINPUT, "failed to unmarshal input")
+	if decoded := decodeChaincodeError(t, err); decoded.Code != ERRINVALIDINPUT {
+		t.Fatalf("expected code %s, got %s", ERRINVALIDINPUT, decoded.Code)
+	}
+}
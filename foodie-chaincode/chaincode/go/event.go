@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// EVENTLEG is a single balance movement. Mints have no From and burns have no To.
+type EVENTLEG struct {
+	ID     string `json:"Id"`
+	From   string `json:"From"`
+	To     string `json:"To"`
+	Amount AMOUNT `json:"Amount"`
+}
+
+// EVENT is the chaincode event payload. Fabric keeps a single event per transaction, so every
+// balance movement of the transaction is listed in Legs; the top level fields repeat the leg when
+// there is exactly one. Pause and freeze events have no legs and carry the changed state in Pause
+// or Freeze.
+type EVENT struct {
+	Type       string        `json:"Type"`
+	ID         string        `json:"Id"`
+	From       string        `json:"From"`
+	To         string        `json:"To"`
+	Amount     AMOUNT        `json:"Amount"`
+	TxnID      string        `json:"TxnId"`
+	FabricTxID string        `json:"FabricTxId"`
+	Timestamp  time.Time     `json:"Timestamp"`
+	Legs       []EVENTLEG    `json:"Legs"`
+	Pause      *PAUSESTRUCT  `json:"Pause,omitempty"`
+	Freeze     *FREEZESTRUCT `json:"Freeze,omitempty"`
+}
+
+const MINTEVENT = "Mint"
+const TRANSFEREVENT = "Transfer"
+const BURNEVENT = "Burn"
+
+// emitEvent sets the chaincode event for the current transaction.
+func emitEvent(ctx contractapi.TransactionContextInterface, eventType string, txnId string, legs []EVENTLEG) error {
+	event, err := newEvent(ctx, eventType, txnId, legs)
+	if err != nil {
+		return err
+	}
+
+	return setEvent(ctx, event)
+}
+
+// newEvent builds the event of the current transaction without setting it.
+func newEvent(ctx contractapi.TransactionContextInterface, eventType string, txnId string, legs []EVENTLEG) (*EVENT, error) {
+	timestamp, err := getTxTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var event EVENT
+	event.Type = eventType
+	event.TxnID = txnId
+	event.FabricTxID = ctx.GetStub().GetTxID()
+	event.Timestamp = timestamp
+	event.Amount = "0"
+	event.Legs = legs
+
+	if len(legs) == 1 {
+		event.ID = legs[0].ID
+		event.From = legs[0].From
+		event.To = legs[0].To
+		event.Amount = legs[0].Amount
+	}
+
+	return &event, nil
+}
+
+// setEvent sets event as the chaincode event of the current transaction.
+func setEvent(ctx contractapi.TransactionContextInterface, event *EVENT) error {
+	eventAsByte, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	err = ctx.GetStub().SetEvent(event.Type, eventAsByte)
+	if err != nil {
+		return fmt.Errorf("failed to set event: %v", err)
+	}
+
+	return nil
+}
+
+// getTxTimestamp returns the client supplied transaction timestamp, which is identical on every
+// endorsing peer.
+func getTxTimestamp(ctx contractapi.TransactionContextInterface) (time.Time, error) {
+	txTimestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get transaction timestamp: %w", err)
+	}
+
+	timestamp, err := ptypes.Timestamp(txTimestamp)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to convert transaction timestamp: %w", err)
+	}
+
+	return timestamp.UTC(), nil
+}
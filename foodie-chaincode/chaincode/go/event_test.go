@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// lastEvent drains the events set so far and decodes the most recent one.
+func (l *balanceTestLedger) lastEvent() (string, *EVENT) {
+	l.t.Helper()
+	var name string
+	var payload []byte
+	for len(l.stub.ChaincodeEventsChannel) > 0 {
+		event := <-l.stub.ChaincodeEventsChannel
+		name, payload = event.EventName, event.Payload
+	}
+	if payload == nil {
+		l.t.Fatal("expected an event")
+	}
+
+	var event EVENT
+	err := json.Unmarshal(payload, &event)
+	if err != nil {
+		l.t.Fatal(err)
+	}
+	return name, &event
+}
+
+func TestMintEventPayload(t *testing.T) {
+	ledger := newBalanceTestLedger(t)
+	ledger.createToken(TOKENDEF{ID: "MEAL", IssuerOrg: "Org1MSP", Transferable: true, DocType: TOKEN})
+	ledger.registerAccount("minter", MINTERROLE)
+	ledger.registerAccount("alice", STUDENTROLE)
+
+	if _, err := ledger.mint("minter", FOODIE{TxnID: "m1", ID: "MEAL", UserId: "alice", Amount: "25"}); err != nil {
+		t.Fatal(err)
+	}
+
+	name, event := ledger.lastEvent()
+	if name != MINTEVENT || event.Type != MINTEVENT || event.TxnID != "m1" || event.FabricTxID == "" {
+		t.Fatalf("unexpected event %s: %+v", name, event)
+	}
+	if event.ID != "MEAL" || event.From != "" || event.To != "alice" || event.Amount != "25" || len(event.Legs) != 1 {
+		t.Fatalf("unexpected event legs: %+v", event)
+	}
+	if event.Pause != nil || event.Freeze != nil {
+		t.Fatalf("expected no pause or freeze in a mint event: %+v", event)
+	}
+}
+
+func TestPauseAndFreezeEventsUseTheEventEnvelope(t *testing.T) {
+	ledger := newBalanceTestLedger(t)
+	ledger.createToken(TOKENDEF{ID: "MEAL", IssuerOrg: "Org1MSP", Transferable: true, DocType: TOKEN})
+	ledger.registerAccount("admin", ADMINROLE)
+	ledger.registerAccount("alice", STUDENTROLE)
+
+	err := ledger.runAs(newTestIdentity("admin"), func(ctx contractapi.TransactionContextInterface) error {
+		return new(SmartContract).Pause(ctx, "MEAL", "stock take")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, event := ledger.lastEvent()
+	if name != PAUSEEVENT || event.Type != PAUSEEVENT || event.ID != "MEAL" || event.Amount != "0" || len(event.Legs) != 0 {
+		t.Fatalf("unexpected event %s: %+v", name, event)
+	}
+	if event.Pause == nil || !event.Pause.Paused || event.Pause.Reason != "stock take" {
+		t.Fatalf("expected the pause in the event, got %+v", event.Pause)
+	}
+
+	err = ledger.run(func(ctx contractapi.TransactionContextInterface) error {
+		return putFreeze(ctx, &FREEZESTRUCT{UserID: "alice", ID: "MEAL", Frozen: true, ReasonCode: LOSTCARDREASON, DocType: FREEZE}, FREEZEEVENT)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, event = ledger.lastEvent()
+	if name != FREEZEEVENT || event.Type != FREEZEEVENT || event.ID != "MEAL" {
+		t.Fatalf("unexpected event %s: %+v", name, event)
+	}
+	if event.Freeze == nil || event.Freeze.UserID != "alice" || !event.Freeze.Frozen {
+		t.Fatalf("expected the freeze in the event, got %+v", event.Freeze)
+	}
+}
@@ -4,10 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"math/big"
 	"time"
 
 	"github.com/golang/protobuf/ptypes"
-	"github.com/hyperledger/fabric-chaincode-go/shim"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 )
 
@@ -15,48 +15,59 @@ type SmartContract struct {
 	contractapi.Contract
 }
 
+// FOODIE is the Mint payload and the total supply record of a token. Fields tagged optional may be
+// left out of the payload; the metadata tags shape the schema published for Mint. ExpiresAt is the
+// expiry of the minted lot of an Expiring token.
 type FOODIE struct {
-	OrgName     string `json:"OrgName"`
+	OrgName     string `json:"OrgName" metadata:"OrgName,optional"`
 	UserId      string `json:"UserId"`
 	TxnID       string `json:"TxnId"`
 	ID          string `json:"Id"`
-	DocType     string `json:"DocType"`
-	Amount      int    `json:"Amount"`
-	TotalSupply int    `json:"TotalSupply"`
+	DocType     string `json:"DocType" metadata:"DocType,optional"`
+	Amount      AMOUNT `json:"Amount"`
+	TotalSupply AMOUNT `json:"TotalSupply" metadata:"TotalSupply,optional"`
+	ExpiresAt   string `json:"ExpiresAt,omitempty" metadata:"ExpiresAt,optional"`
 }
 
+// TRANSFER is the Transfer payload and the record stored for every transfer.
 type TRANSFER struct {
-	TxnID    string `json:"TxnId"`
-	ID       string `json:"Id"`
-	DocType  string `json:"DocType"`
-	Amount   int    `json:"Amount"`
-	UserId   string `json:"UserId"`
-	Receiver string `json:"Receiver"`
+	TxnID     string   `json:"TxnId"`
+	ID        string   `json:"Id"`
+	DocType   string   `json:"DocType" metadata:"DocType,optional"`
+	Amount    AMOUNT   `json:"Amount"`
+	UserId    string   `json:"UserId"`
+	Receiver  string   `json:"Receiver"`
+	Spender   string   `json:"Spender,omitempty" metadata:"Spender,optional"`
+	BatchID   string   `json:"BatchId,omitempty" metadata:"BatchId,optional"`
+	Inputs    []string `json:"Inputs,omitempty" metadata:"Inputs,optional"`
+	Timestamp string   `json:"Timestamp,omitempty" metadata:"Timestamp,optional"`
 }
 
 type TXN struct {
-	UserID  string `json:"UserId"`
-	TxnID   string `json:"TxnId"`
-	ID      string `json:"Id"`
-	DocType string `json:"DocType"`
-	Amount  int    `json:"Amount"`
+	UserID    string `json:"UserId"`
+	TxnID     string `json:"TxnId"`
+	ID        string `json:"Id"`
+	DocType   string `json:"DocType"`
+	Amount    AMOUNT `json:"Amount"`
+	Timestamp string `json:"Timestamp,omitempty"`
 }
 
 type OWNERSTRUCT struct {
 	ID      string `json:"Id"`
 	UserID  string `json:"UserId"`
 	DocType string `json:"DocType"`
-	Amount  int    `json:"Amount"`
+	Amount  AMOUNT `json:"Amount"`
 }
 
+// BURNTOKEN is the Burn payload.
 type BURNTOKEN struct {
-	OrgName         string `json:"OrgName"`
+	OrgName         string `json:"OrgName" metadata:"OrgName,optional"`
 	TxnID           string `json:"TxnId"`
 	ID              string `json:"Id"`
-	DocType         string `json:"DocType"`
-	UserID          string `json:"UserId"`
+	DocType         string `json:"DocType" metadata:"DocType,optional"`
+	UserID          string `json:"UserId" metadata:"UserId,optional"`
 	BurnTokenID     string `json:"BurnTokenId"`
-	BurnTokenAmount int    `json:"BurnTokenAmount"`
+	BurnTokenAmount AMOUNT `json:"BurnTokenAmount"`
 }
 
 type BURNTXN struct {
@@ -65,7 +76,9 @@ type BURNTXN struct {
 	DocType         string `json:"DocType"`
 	UserID          string `json:"UserId"`
 	BurnTokenID     string `json:"BurnTokenId"`
-	BurnTokenAmount int    `json:"BurnTokenAmount"`
+	BurnTokenAmount AMOUNT `json:"BurnTokenAmount"`
+	Reason          string `json:"Reason,omitempty"`
+	Timestamp       string `json:"Timestamp,omitempty"`
 }
 
 type HistoryQueryResult struct {
@@ -75,54 +88,99 @@ type HistoryQueryResult struct {
 	IsDelete  bool      `json:"isDelete"`
 }
 
+// BalanceHistoryResult is one version of an owner entry. Delta is the change from the previous version.
+type BalanceHistoryResult struct {
+	Record    *OWNERSTRUCT `json:"record"`
+	TxId      string       `json:"txId"`
+	Timestamp time.Time    `json:"timestamp"`
+	IsDelete  bool         `json:"isDelete"`
+	Delta     AMOUNT       `json:"delta"`
+}
+
 const MINTTXN = "MINTTX"
 const OWNER = "OWNER"
 const TRANSFERTXN = "TRANSFERTXN"
 const DOCTYPE = "foodie"
 const BURN = "BURNTXN"
 
-func (s *SmartContract) Mint(ctx contractapi.TransactionContextInterface, input string) error {
+// MintTyped is Mint with input passed as a JSON object whose schema is published in the contract
+// metadata, so amounts must be strings and unknown fields are refused.
+func (s *SmartContract) MintTyped(ctx contractapi.TransactionContextInterface, input FOODIE) (*RECEIPT, error) {
+	return mint(ctx, &input)
+}
+
+// Mint credits Amount of token Id to UserId and raises the token's total supply. Only a Minter of
+// the token's issuer org can mint. input is a JSON string, which may hold amounts as JSON numbers.
+// The receipt of the request is returned, also when an identical request with the same TxnId was
+// already applied.
+func (s *SmartContract) Mint(ctx contractapi.TransactionContextInterface, input string) (*RECEIPT, error) {
 	// Unmarshal the input JSON into a foodieInput structure
 	var foodieInput FOODIE
-	err := json.Unmarshal([]byte(input), &foodieInput)
+	err := decodeInput(input, &foodieInput)
 	if err != nil {
-		return fmt.Errorf("failed to unmarshal input: %w", err)
+		return nil, err
 	}
-	fmt.Println("Unmarshaled input data:", foodieInput)
 
-	// Retrieve the client's MSPID
-	clientMSPID, err := ctx.GetClientIdentity().GetMSPID()
+	return mint(ctx, &foodieInput)
+}
+
+func mint(ctx contractapi.TransactionContextInterface, foodieInput *FOODIE) (*RECEIPT, error) {
+	err := validateMintInput(foodieInput)
 	if err != nil {
-		return fmt.Errorf("failed to get MSPID: %w", err)
+		return nil, err
 	}
-	fmt.Println("Client MSPID:", clientMSPID)
+	fmt.Println("Unmarshaled input data:", *foodieInput)
 
-	// Ensure only Org1 is authorized to mint tokens
-	if clientMSPID != "Org1MSP" {
-		return fmt.Errorf("client is not authorized to mint new tokens")
+	// Ensure only a Minter can mint tokens
+	minter, err := assertCallerRole(ctx, MINTERROLE)
+	if err != nil {
+		return nil, err
 	}
+	fmt.Println("Minter ID:", minter)
 
-	// Get the user's role (Minter or student)
-	getUserRole, _, err := ctx.GetClientIdentity().GetAttributeValue("UserRole")
+	// Return the original receipt if this request was already applied
+	receipt, requestHash, err := checkReceipt(ctx, foodieInput.TxnID, MINTEVENT, foodieInput)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if receipt != nil {
+		return receipt, nil
 	}
 
-	// Ensure only Minter can mint tokens
-	if getUserRole != "Minter" {
-		return fmt.Errorf("only Minter can mint the token")
+	// Refuse the request while the contract or the token is paused
+	err = assertNotPaused(ctx, foodieInput.ID)
+	if err != nil {
+		return nil, err
 	}
 
-	// Retrieve the minter's ID
-	minter, err := ctx.GetClientIdentity().GetID()
+	// Ensure the token is registered and the caller's org is its issuer
+	token, err := getRegisteredToken(ctx, foodieInput.ID)
 	if err != nil {
-		return fmt.Errorf("failed to get minter ID")
+		return nil, err
 	}
-	fmt.Println("Minter ID:", minter)
 
-	// Validate that the mint amount is greater than zero
-	if foodieInput.Amount <= 0 {
-		return fmt.Errorf("mint amount must be greater than zero")
+	clientMSPID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get MSPID: %w", err)
+	}
+	if clientMSPID != token.IssuerOrg {
+		return nil, newError(ERRUNAUTHORIZED, "client org %s is not the issuer of token %s", clientMSPID, token.ID)
+	}
+
+	// Ensure the tokens are minted to an account someone owns
+	err = assertAccountRegistered(ctx, foodieInput.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	// Refuse mints outside the token's validity window and settle the expiry of the minted lot
+	err = assertTokenValid(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	expiresAt, err := lotExpiry(ctx, token, foodieInput.ExpiresAt)
+	if err != nil {
+		return nil, err
 	}
 
 	// Create a transaction object for minting
@@ -132,89 +190,179 @@ func (s *SmartContract) Mint(ctx contractapi.TransactionContextInterface, input
 	txn.Amount = foodieInput.Amount
 	txn.DocType = MINTTXN
 	txn.TxnID = foodieInput.TxnID
+	txn.Timestamp, err = getRecordTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	// Create a composite key for the transaction
 	indexName := "TxnID~" + DOCTYPE //DOCTYPE = foodie
 	TxnCompositeKey, err := ctx.GetStub().CreateCompositeKey(indexName, []string{txn.TxnID, txn.ID})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Check for duplicate transactions
 	checkTxnDuplication, err := ctx.GetStub().GetState(TxnCompositeKey)
 	if err != nil {
-		return fmt.Errorf("error checking transaction duplication: %w", err)
+		return nil, fmt.Errorf("error checking transaction duplication: %w", err)
 	}
 
 	if checkTxnDuplication != nil {
 		fmt.Println("Duplicate transaction found")
-		return fmt.Errorf("duplicate transaction")
+		return nil, newError(ERRDUPLICATE, "duplicate transaction")
 	}
 
-	// Retrieve the current state for the given foodieInput.ID
-	forTotalSupply, err := ctx.GetStub().GetState(foodieInput.ID)
-	if err != nil {
-		return err
-	}
-	fmt.Println("Current total supply state:", string(forTotalSupply))
-
-	// Update the total supply based on current state
-	if forTotalSupply == nil {
-		foodieInput.TotalSupply = foodieInput.Amount
-	} else {
-		var currFoodie FOODIE
-		err = json.Unmarshal(forTotalSupply, &currFoodie)
+	// Delta-write tokens only need the current supply to enforce a cap
+	if !token.DeltaWrites || hasMaxSupply(token) {
+		currentSupply, err := getTotalSupply(ctx, foodieInput.ID)
 		if err != nil {
-			return fmt.Errorf("failed to unmarshal total supply: %w", err)
+			return nil, err
 		}
+
 		// Update the total supply
-		foodieInput.TotalSupply = currFoodie.TotalSupply + foodieInput.Amount
+		foodieInput.TotalSupply, err = addAmounts(currentSupply, foodieInput.Amount)
+		if err != nil {
+			return nil, wrapError(err, ERRINTERNAL, "failed to update total supply")
+		}
 		fmt.Println("Updated total supply:", foodieInput)
+
+		// Refuse mints past the token's supply cap
+		exceeded, err := exceedsMaxSupply(token, foodieInput.TotalSupply)
+		if err != nil {
+			return nil, err
+		}
+		if exceeded {
+			return nil, newError(ERRINVALIDINPUT, "mint would exceed max supply %s of token %s", token.MaxSupply, token.ID)
+		}
 	}
 
 	// Add the balance to the owner's account
 	err = addBalance(ctx, foodieInput.UserId, foodieInput.ID, foodieInput.Amount)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Marshal the foodieInput and store it on the ledger
-	foodieAsByte, err := json.Marshal(foodieInput)
-	if err != nil {
-		return fmt.Errorf("failed to marshal foodieInput: %w", err)
+	if token.Expiring {
+		err = creditLots(ctx, foodieInput.UserId, []*LOTSTRUCT{{ID: token.ID, ExpiresAt: expiresAt, TxnID: txn.TxnID, Amount: txn.Amount}})
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	err = ctx.GetStub().PutState(foodieInput.ID, foodieAsByte)
-	if err != nil {
-		return fmt.Errorf("failed to store foodie state: %v", err)
+	if token.DeltaWrites {
+		err = putSupplyDelta(ctx, foodieInput.ID, foodieInput.Amount)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// Marshal the foodieInput and store it on the ledger
+		foodieAsByte, err := json.Marshal(foodieInput)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal foodieInput: %w", err)
+		}
+
+		err = ctx.GetStub().PutState(foodieInput.ID, foodieAsByte)
+		if err != nil {
+			return nil, fmt.Errorf("failed to store foodie state: %v", err)
+		}
 	}
 
 	// Marshal the transaction and store it on the ledger
 	TXNAsByte, err := json.Marshal(txn)
 	if err != nil {
-		return fmt.Errorf("failed to marshal transaction: %w", err)
+		return nil, fmt.Errorf("failed to marshal transaction: %w", err)
 	}
 
 	err = ctx.GetStub().PutState(TxnCompositeKey, TXNAsByte)
 	if err != nil {
-		return fmt.Errorf("failed to store transaction state: %v", err)
+		return nil, fmt.Errorf("failed to store transaction state: %v", err)
 	}
 
-	return nil
+	// Index the mint under the credited account
+	err = indexTxn(ctx, TxnCompositeKey, txn.ID, "", txn.UserID, txn.Timestamp, txn.TxnID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Emit the mint event
+	legs := []EVENTLEG{{ID: txn.ID, To: txn.UserID, Amount: txn.Amount}}
+	err = emitEvent(ctx, MINTEVENT, txn.TxnID, legs)
+	if err != nil {
+		return nil, err
+	}
+
+	return putReceipt(ctx, foodieInput.TxnID, MINTEVENT, requestHash, legs)
+}
+
+// TransferTyped is Transfer with input passed as a JSON object whose schema is published in the
+// contract metadata.
+func (s *SmartContract) TransferTyped(ctx contractapi.TransactionContextInterface, input TRANSFER) (*RECEIPT, error) {
+	return transfer(ctx, &input)
 }
 
-func (s *SmartContract) Transfer(ctx contractapi.TransactionContextInterface, input string) error {
+// Transfer moves Amount of token Id from UserId to Receiver. input is a JSON string, which may hold
+// amounts as JSON numbers. Retries are handled like Mint.
+func (s *SmartContract) Transfer(ctx contractapi.TransactionContextInterface, input string) (*RECEIPT, error) {
 	// Unmarshal the input JSON into a transferInput structure
 	var transferInput TRANSFER
-	err := json.Unmarshal([]byte(input), &transferInput)
+	err := decodeInput(input, &transferInput)
 	if err != nil {
-		return fmt.Errorf("failed to unmarshal input: %w", err)
+		return nil, err
 	}
-	fmt.Println("Unmarshaled input data:", transferInput)
 
-	// Ensure the transfer amount is positive
-	if transferInput.Amount <= 0 {
-		return fmt.Errorf("transfer amount must be greater than zero")
+	return transfer(ctx, &transferInput)
+}
+
+func transfer(ctx contractapi.TransactionContextInterface, transferInput *TRANSFER) (*RECEIPT, error) {
+	err := validateTransferInput(transferInput)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("Unmarshaled input data:", *transferInput)
+
+	// Ensure the caller is allowed to spend and owns the account being debited
+	_, err = assertCallerRole(ctx, STUDENTROLE, MERCHANTROLE)
+	if err != nil {
+		return nil, err
+	}
+
+	err = assertAccountOwner(ctx, transferInput.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	// Return the original receipt if this request was already applied
+	receipt, requestHash, err := checkReceipt(ctx, transferInput.TxnID, TRANSFEREVENT, transferInput)
+	if err != nil {
+		return nil, err
+	}
+	if receipt != nil {
+		return receipt, nil
+	}
+
+	// Refuse the request while the contract or the token is paused
+	err = assertNotPaused(ctx, transferInput.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ensure the token is registered and can be transferred
+	token, err := getRegisteredToken(ctx, transferInput.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Transferable {
+		return nil, newError(ERRINVALIDINPUT, "token %s is not transferable", token.ID)
+	}
+	if len(transferInput.Inputs) > 0 && !token.UTXO {
+		return nil, newError(ERRINVALIDINPUT, "token %s does not use the UTXO model", token.ID)
+	}
+
+	// Ensure the receiver is an account someone owns
+	err = assertAccountRegistered(ctx, transferInput.Receiver)
+	if err != nil {
+		return nil, err
 	}
 
 	//DocType change TransferTxn
@@ -225,79 +373,138 @@ func (s *SmartContract) Transfer(ctx contractapi.TransactionContextInterface, in
 	txn.TxnID = transferInput.TxnID
 	txn.Receiver = transferInput.Receiver
 	txn.UserId = transferInput.UserId
+	txn.Inputs = transferInput.Inputs
+	txn.Timestamp, err = getRecordTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	// Create a composite key for the transaction
 	indexName := "TxnID~" + DOCTYPE
 	TxnCompositeKey, err := ctx.GetStub().CreateCompositeKey(indexName, []string{txn.TxnID, txn.ID})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Check if the transaction already exists
 	checkTxnDuplication, err := ctx.GetStub().GetState(TxnCompositeKey)
 	if err != nil {
-		return fmt.Errorf("error checking transaction duplication: %w", err)
+		return nil, fmt.Errorf("error checking transaction duplication: %w", err)
 	}
 	fmt.Println("Transaction composite key:", checkTxnDuplication)
 
 	// Validate for duplicate transactions
 	if checkTxnDuplication != nil {
 		fmt.Println("Duplicate transaction found")
-		return fmt.Errorf("duplicate transaction")
+		return nil, newError(ERRDUPLICATE, "duplicate transaction")
 	}
 
-	// Remove the specified balance from the owner's account
-	err = removeBalance(ctx, transferInput.UserId, transferInput.ID, transferInput.Amount)
+	// Remove the specified balance from the owner's account, spending the chosen outputs if given
+	if len(transferInput.Inputs) > 0 {
+		err = assertNotFrozen(ctx, transferInput.UserId, transferInput.ID, false)
+		if err != nil {
+			return nil, err
+		}
+		err = spendOutputs(ctx, transferInput.UserId, transferInput.ID, transferInput.Amount, transferInput.Inputs)
+	} else {
+		err = removeBalance(ctx, transferInput.UserId, transferInput.ID, transferInput.Amount)
+	}
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	// Hand the earliest-expiring lots of an Expiring token to the receiver
+	err = moveLots(ctx, transferInput.UserId, transferInput.Receiver, transferInput.ID, transferInput.Amount)
+	if err != nil {
+		return nil, err
 	}
 
 	// Add the specified balance to the receiver's account
 	err = addBalance(ctx, transferInput.Receiver, transferInput.ID, transferInput.Amount)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Marshal the transaction input and store it on the ledger
 	TXNAsByte, err := json.Marshal(txn)
 	if err != nil {
-		return fmt.Errorf("failed to marshal transfer input: %w", err)
+		return nil, fmt.Errorf("failed to marshal transfer input: %w", err)
 	}
 
 	err = ctx.GetStub().PutState(TxnCompositeKey, TXNAsByte)
 	if err != nil {
-		return fmt.Errorf("failed to store composite key state: %v", err)
+		return nil, fmt.Errorf("failed to store composite key state: %v", err)
 	}
 
-	return nil
+	// Index the transfer under both accounts
+	err = indexTxn(ctx, TxnCompositeKey, txn.ID, txn.UserId, txn.Receiver, txn.Timestamp, txn.TxnID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Emit the transfer event
+	legs := []EVENTLEG{{ID: txn.ID, From: txn.UserId, To: txn.Receiver, Amount: txn.Amount}}
+	err = emitEvent(ctx, TRANSFEREVENT, txn.TxnID, legs)
+	if err != nil {
+		return nil, err
+	}
+
+	return putReceipt(ctx, transferInput.TxnID, TRANSFEREVENT, requestHash, legs)
 }
 
-func (s *SmartContract) Burn(ctx contractapi.TransactionContextInterface, input string) error {
+// BurnTyped is Burn with input passed as a JSON object whose schema is published in the contract
+// metadata.
+func (s *SmartContract) BurnTyped(ctx contractapi.TransactionContextInterface, input BURNTOKEN) (*RECEIPT, error) {
+	return burn(ctx, &input)
+}
+
+// Burn destroys BurnTokenAmount of token Id held by BurnTokenId and lowers the token's total
+// supply. input is a JSON string, which may hold amounts as JSON numbers. Retries are handled like
+// Mint.
+func (s *SmartContract) Burn(ctx contractapi.TransactionContextInterface, input string) (*RECEIPT, error) {
 	// Unmarshal input JSON into burnTokenInput structure
 	var burnTokenInput BURNTOKEN
-	err := json.Unmarshal([]byte(input), &burnTokenInput)
+	err := decodeInput(input, &burnTokenInput)
 	if err != nil {
-		return fmt.Errorf("failed to unmarshal input: %w", err)
+		return nil, err
 	}
-	fmt.Println("Unmarshaled input data:", burnTokenInput)
 
-	// Retrieve client's MSPID
-	clientMSPID, err := ctx.GetClientIdentity().GetMSPID()
+	return burn(ctx, &burnTokenInput)
+}
+
+func burn(ctx contractapi.TransactionContextInterface, burnTokenInput *BURNTOKEN) (*RECEIPT, error) {
+	err := validateBurnInput(burnTokenInput)
 	if err != nil {
-		return fmt.Errorf("failed to get MSPID: %w", err)
+		return nil, err
 	}
-	fmt.Println("Client MSPID:", clientMSPID)
+	fmt.Println("Unmarshaled input data:", *burnTokenInput)
 
-	// Get the user's role
-	getUserRole, _, err := ctx.GetClientIdentity().GetAttributeValue("UserRole")
+	// Ensure only a Burner can burn tokens
+	burner, err := assertCallerRole(ctx, BURNERROLE)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	fmt.Println("Burner ID:", burner)
+
+	// Ensure the caller owns the account being debited
+	err = assertAccountOwner(ctx, burnTokenInput.BurnTokenID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Return the original receipt if this request was already applied
+	receipt, requestHash, err := checkReceipt(ctx, burnTokenInput.TxnID, BURNEVENT, burnTokenInput)
+	if err != nil {
+		return nil, err
+	}
+	if receipt != nil {
+		return receipt, nil
 	}
-	fmt.Println("Get User Role - ", getUserRole)
 
-	// Authorize only specific roles to burn tokens
-	if getUserRole != "Minter" && clientMSPID != "Org1MSP" {
-		return fmt.Errorf("client is not authorized to burn tokens or contact college")
+	// Refuse the request while the contract or the token is paused
+	err = assertNotPaused(ctx, burnTokenInput.ID)
+	if err != nil {
+		return nil, err
 	}
 
 	// Create a burn transaction object
@@ -308,144 +515,91 @@ func (s *SmartContract) Burn(ctx contractapi.TransactionContextInterface, input
 	burntxn.DocType = BURN
 	burntxn.TxnID = burnTokenInput.TxnID
 	burntxn.BurnTokenAmount = burnTokenInput.BurnTokenAmount
+	burntxn.Timestamp, err = getRecordTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	// Create a composite key for the transaction
 	indexName := "TxnID~" + DOCTYPE
 	TxnCompositeKey, err := ctx.GetStub().CreateCompositeKey(indexName, []string{burntxn.TxnID, burntxn.ID})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Check for duplicate transactions
 	checkTxnDuplication, err := ctx.GetStub().GetState(TxnCompositeKey)
 	if err != nil {
-		return fmt.Errorf("error checking transaction duplication: %w", err)
+		return nil, fmt.Errorf("error checking transaction duplication: %w", err)
 	}
 	if checkTxnDuplication != nil {
 		fmt.Println("Duplicate transaction found")
-		return fmt.Errorf("duplicate transaction")
+		return nil, newError(ERRDUPLICATE, "duplicate transaction")
 	}
 
 	// Burn the specified amount of tokens
 	err = removeBalance(ctx, burnTokenInput.BurnTokenID, burnTokenInput.ID, burnTokenInput.BurnTokenAmount)
 	if err != nil {
-		return err
-	}
-
-	// Retrieve the current total supply
-	forTotalSupply, err := ctx.GetStub().GetState(burnTokenInput.ID)
-	if err != nil {
-		return err
-	}
-	fmt.Println("Current total supply state:", string(forTotalSupply))
-
-	var currFoodie FOODIE
-	// Unmarshal the total supply state
-	err = json.Unmarshal(forTotalSupply, &currFoodie)
-	if err != nil {
-		return fmt.Errorf("failed to unmarshal total supply: %w", err)
+		return nil, err
 	}
 
-	// Ensure total supply is not nil
-	if forTotalSupply == nil {
-		return fmt.Errorf("total supply is nil")
-	} else {
-		// Decrease the total supply by the burned amount
-		currFoodie.TotalSupply -= burnTokenInput.BurnTokenAmount
-		fmt.Println("Updated total supply:", currFoodie)
-	}
-
-	// Marshal the updated foodie state for storage
-	foodieAsByte, err := json.Marshal(currFoodie)
+	// Burn the earliest-expiring lots of an Expiring token, including lots that already expired
+	_, err = spendLots(ctx, burnTokenInput.BurnTokenID, burnTokenInput.ID, burnTokenInput.BurnTokenAmount, true)
 	if err != nil {
-		return fmt.Errorf("failed to marshal foodie input: %w", err)
+		return nil, err
 	}
 
-	// Store the updated foodie state on the ledger
-	err = ctx.GetStub().PutState(currFoodie.ID, foodieAsByte)
+	err = decreaseSupply(ctx, burnTokenInput.ID, burnTokenInput.BurnTokenAmount)
 	if err != nil {
-		return fmt.Errorf("failed to store foodie state: %v", err)
+		return nil, err
 	}
 
 	// Marshal the burn transaction for storage
 	TXNAsByte, err := json.Marshal(burntxn)
 	if err != nil {
-		return fmt.Errorf("failed to marshal transaction: %w", err)
+		return nil, fmt.Errorf("failed to marshal transaction: %w", err)
 	}
 
 	// Store the burn transaction state on the ledger
 	err = ctx.GetStub().PutState(TxnCompositeKey, TXNAsByte)
 	if err != nil {
-		return fmt.Errorf("failed to store transaction state: %v", err)
+		return nil, fmt.Errorf("failed to store transaction state: %v", err)
 	}
 
-	return nil
-}
-
-func (s *SmartContract) GetBalance(ctx contractapi.TransactionContextInterface, user string, id string) (int, error) {
-
-	// Create a composite key for the owner entry
-	var indexName = DOCTYPE + "~Owner"
-	ownerKey, err := ctx.GetStub().CreateCompositeKey(indexName, []string{id, user})
+	// Index the burn under the debited account
+	err = indexTxn(ctx, TxnCompositeKey, burntxn.ID, burntxn.BurnTokenID, "", burntxn.Timestamp, burntxn.TxnID)
 	if err != nil {
-		return 0, fmt.Errorf("failed to create composite key in add balance: %w", err)
+		return nil, err
 	}
-	fmt.Println("Owner key for adding balance:", ownerKey)
 
-	// Retrieve the current state from the ledger
-	checkOwnerEntry, err := ctx.GetStub().GetState(ownerKey)
+	// Emit the burn event
+	legs := []EVENTLEG{{ID: burntxn.ID, From: burnTokenInput.BurnTokenID, Amount: burntxn.BurnTokenAmount}}
+	err = emitEvent(ctx, BURNEVENT, burntxn.TxnID, legs)
 	if err != nil {
-		return 0, fmt.Errorf("failed to fetch owner entry: %w", err)
+		return nil, err
 	}
-	fmt.Println("Current owner entry state:", string(checkOwnerEntry))
 
-	var checkOwner OWNERSTRUCT
-	if checkOwnerEntry != nil {
-		// Unmarshal the existing data from the ledger
-		err = json.Unmarshal(checkOwnerEntry, &checkOwner)
+	return putReceipt(ctx, burnTokenInput.TxnID, BURNEVENT, requestHash, legs)
+}
+
+func (s *SmartContract) GetBalance(ctx contractapi.TransactionContextInterface, user string, id string) (AMOUNT, error) {
+	// Only the account owner, an Admin or an Auditor can read a balance
+	err := assertAccountOwner(ctx, user)
+	if err != nil {
+		_, err = assertCallerRole(ctx, ADMINROLE, AUDITORROLE)
 		if err != nil {
-			return 0, fmt.Errorf("failed to unmarshal existing owner entry: %w", err)
+			return "", err
 		}
-		fmt.Printf("Owner balance for user %s is %d - \n", user, checkOwner.Amount)
 	}
 
-	return checkOwner.Amount, nil
-}
-
-// get History foodie
-// list of owners
-// GetQuery retrieves transactions based on the specified owner.
-// It constructs a query string to select documents of type "TRANSFERTXN" for the given owner.
-func (s *SmartContract) GetQuery(ctx contractapi.TransactionContextInterface, owner string) ([]*TXN, error) {
-	// Construct the query string to select transactions for the specified owner.
-	queryString := fmt.Sprintf(`{"selector":{"DocType":"%s"}}`, owner)
-	
-	// Execute the query and get the results.
-	output, err := getQueryResultForQueryString(ctx, queryString)
-	if err != nil {
-		return nil, err // Return an error if the query fails.
-	}
-	fmt.Println(output) // Log the output for debugging.
-	
-	// Return the results of the query.
-	return getQueryResultForQueryString(ctx, queryString)
-}
+	// Read the owner entry together with any unconsolidated deltas
+	balance, err := getOwnerBalance(ctx, user, id)
+	if err != nil {
+		return "", err
+	}
+	fmt.Printf("Owner balance for user %s is %s - \n", user, balance)
 
-// GetAllOwners retrieves all transactions associated with a given owner.
-// This function behaves similarly to GetQuery, but is named to imply it retrieves all records for that owner.
-func (s *SmartContract) GetAllOwners(ctx contractapi.TransactionContextInterface, owner string) ([]*TXN, error) {
-	// Construct the query string to select all transactions for the specified owner.
-	queryString := fmt.Sprintf(`{"selector":{"DocType":"%s"}}`, owner)
-	
-	// Execute the query and get the results.
-	output, err := getQueryResultForQueryString(ctx, queryString)
-	if err != nil {
-		return nil, err // Return an error if the query fails.
-	}
-	fmt.Println(output) // Log the output for debugging.
-	
-	// Return the results of the query.
-	return getQueryResultForQueryString(ctx, queryString)
+	return balance, nil
 }
 
 // GetAssetHistory retrieves the history of a specific asset based on its ID.
@@ -453,6 +607,12 @@ func (s *SmartContract) GetAllOwners(ctx contractapi.TransactionContextInterface
 func (s *SmartContract) GetAssetHistory(ctx contractapi.TransactionContextInterface, assetID string) ([]HistoryQueryResult, error) {
 	log.Printf("GetAssetHistory: ID %v", assetID) // Log the asset ID for tracking.
 
+	// Only an Admin or an Auditor can read asset history.
+	_, err := assertCallerRole(ctx, ADMINROLE, AUDITORROLE)
+	if err != nil {
+		return nil, err
+	}
+
 	// Get the history of the asset using its ID.
 	resultsIterator, err := ctx.GetStub().GetHistoryForKey(assetID)
 	if err != nil {
@@ -497,42 +657,114 @@ func (s *SmartContract) GetAssetHistory(ctx contractapi.TransactionContextInterf
 		records = append(records, record) // Add the record to the slice.
 	}
 	fmt.Println("records-", records) // Log the retrieved records for debugging.
-	return records, nil // Return the compiled history records.
+	return records, nil              // Return the compiled history records.
 }
 
-// getQueryResultForQueryString executes a query based on the provided query string.
-// It returns the results as a slice of TXN structs.
-func getQueryResultForQueryString(ctx contractapi.TransactionContextInterface, queryString string) ([]*TXN, error) {
-	resultsIterator, err := ctx.GetStub().GetQueryResult(queryString) // Execute the query.
+// GetBalanceHistory retrieves every version of a user's balance entry for token id, oldest first,
+// with the change each transaction made to the balance. Delta-write and UTXO tokens do not keep
+// their balance in the owner entry; use GetStatement for those.
+func (s *SmartContract) GetBalanceHistory(ctx contractapi.TransactionContextInterface, user string, id string) ([]BalanceHistoryResult, error) {
+	log.Printf("GetBalanceHistory: user %v, ID %v", user, id) // Log the owner entry for tracking.
+
+	// Only the account owner, an Admin or an Auditor can read balance history.
+	err := assertAccountOwner(ctx, user)
 	if err != nil {
-		return nil, err // Return an error if the query fails.
+		_, err = assertCallerRole(ctx, ADMINROLE, AUDITORROLE)
+		if err != nil {
+			return nil, err
+		}
 	}
-	defer resultsIterator.Close() // Ensure the iterator is closed after use.
 
-	return constructQueryResponseFromIterator(resultsIterator) // Construct and return the response from the iterator.
-}
+	// Only owner-entry tokens have a history to read
+	token, err := getRegisteredToken(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if token.UTXO || token.DeltaWrites {
+		return nil, newError(ERRINVALIDINPUT, "token %s does not keep a balance history, use GetStatement", id)
+	}
+
+	// Create the composite key of the owner entry.
+	ownerKey, err := ctx.GetStub().CreateCompositeKey(DOCTYPE+"~Owner", []string{id, user})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key for owner entry: %w", err)
+	}
 
-// constructQueryResponseFromIterator converts query results from the iterator into a slice of TXN structs.
-func constructQueryResponseFromIterator(resultsIterator shim.StateQueryIteratorInterface) ([]*TXN, error) {
-	var assets []*TXN // Initialize a slice to hold the assets.
+	// Get the history of the owner entry.
+	resultsIterator, err := ctx.GetStub().GetHistoryForKey(ownerKey)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var records []BalanceHistoryResult
 	for resultsIterator.HasNext() {
-		queryResult, err := resultsIterator.Next() // Retrieve the next result in the iterator.
+		response, err := resultsIterator.Next()
 		if err != nil {
-			return nil, err // Return an error if fetching the next result fails.
+			return nil, err
 		}
-		var asset TXN // Define a variable to hold the asset data.
-		err = json.Unmarshal(queryResult.Value, &asset) // Unmarshal the result value into the asset struct.
+
+		// A deleted entry is reported as a zero balance.
+		owner := OWNERSTRUCT{ID: id, UserID: user, DocType: OWNER}
+		if len(response.Value) > 0 {
+			err = json.Unmarshal(response.Value, &owner)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		timestamp, err := ptypes.Timestamp(response.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+
+		records = append(records, BalanceHistoryResult{
+			TxId:      response.TxId,
+			Timestamp: timestamp,
+			Record:    &owner,
+			IsDelete:  response.IsDelete,
+		})
+	}
+
+	// The peer returns versions newest first in commit order; the client timestamps cannot be
+	// relied on to order transactions of the same block, so only reverse before computing deltas.
+	for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+		records[i], records[j] = records[j], records[i]
+	}
+
+	previous := new(big.Int)
+	for i := range records {
+		current, err := parseAmount(records[i].Record.Amount)
 		if err != nil {
-			return nil, err // Return an error if unmarshalling fails.
+			return nil, err
 		}
-		assets = append(assets, &asset) // Add the asset to the slice.
+		records[i].Delta = toAmount(new(big.Int).Sub(current, previous))
+		previous = current
 	}
 
-	return assets, nil // Return the compiled assets.
+	return records, nil
 }
 
+func addBalance(ctx contractapi.TransactionContextInterface, userId string, id string, amount AMOUNT) error {
+	// Accounts frozen with blocked credits cannot be credited
+	err := assertNotFrozen(ctx, userId, id, true)
+	if err != nil {
+		return err
+	}
+
+	// UTXO tokens credit by creating an output and delta-write tokens through a delta key, instead
+	// of updating the owner entry
+	token, err := getToken(ctx, id)
+	if err != nil {
+		return err
+	}
+	if token != nil && token.UTXO {
+		return putOutput(ctx, userId, id, amount)
+	}
+	if token != nil && token.DeltaWrites {
+		return putBalanceDelta(ctx, userId, id, amount)
+	}
 
-func addBalance(ctx contractapi.TransactionContextInterface, userId string, id string, amount int) error {
 	var OwnerStruct OWNERSTRUCT
 
 	// Create a composite key for the owner entry
@@ -564,7 +796,10 @@ func addBalance(ctx contractapi.TransactionContextInterface, userId string, id s
 			return fmt.Errorf("failed to unmarshal existing owner entry: %w", err)
 		}
 		// Update the owner's balance by adding the new amount
-		OwnerStruct.Amount = checkOwner.Amount + amount
+		OwnerStruct.Amount, err = addAmounts(checkOwner.Amount, amount)
+		if err != nil {
+			return wrapError(err, ERRINTERNAL, "failed to update balance of owner %s", userId)
+		}
 	}
 
 	// Marshal the updated owner structure for storage
@@ -582,7 +817,57 @@ func addBalance(ctx contractapi.TransactionContextInterface, userId string, id s
 	return nil
 }
 
-func removeBalance(ctx contractapi.TransactionContextInterface, userId string, id string, amount int) error {
+func removeBalance(ctx contractapi.TransactionContextInterface, userId string, id string, amount AMOUNT) error {
+	// Ensure the debit amount is positive
+	positive, err := isPositiveAmount(amount)
+	if err != nil {
+		return err
+	}
+	if !positive {
+		return newError(ERRINVALIDINPUT, "debit amount must be greater than zero")
+	}
+
+	// Frozen accounts cannot be debited
+	err = assertNotFrozen(ctx, userId, id, false)
+	if err != nil {
+		return err
+	}
+
+	return debitBalance(ctx, userId, id, amount)
+}
+
+// debitBalance is removeBalance without the amount and freeze checks, for moves made by an Admin
+// such as ReassignAccount.
+func debitBalance(ctx contractapi.TransactionContextInterface, userId string, id string, amount AMOUNT) error {
+	// UTXO tokens spend outputs; delta-write tokens check the aggregated balance and debit through
+	// a delta key
+	token, err := getToken(ctx, id)
+	if err != nil {
+		return err
+	}
+	if token != nil && token.UTXO {
+		return spendOutputs(ctx, userId, id, amount, nil)
+	}
+	if token != nil && token.DeltaWrites {
+		balance, err := getOwnerBalance(ctx, userId, id)
+		if err != nil {
+			return err
+		}
+		sufficient, err := compareAmounts(balance, amount)
+		if err != nil {
+			return err
+		}
+		if sufficient < 0 {
+			return &InsufficientFundsError{UserID: userId, ID: id, Balance: balance, Amount: amount}
+		}
+
+		debit, err := negateAmount(amount)
+		if err != nil {
+			return err
+		}
+		return putBalanceDelta(ctx, userId, id, debit)
+	}
+
 	var OwnerStruct OWNERSTRUCT
 
 	// Create a composite key for the owner entry
@@ -606,22 +891,34 @@ func removeBalance(ctx contractapi.TransactionContextInterface, userId string, i
 	}
 	fmt.Println("Current owner entry state for removal:", string(checkOwnerEntry))
 
+	// An owner without an entry has nothing to debit
+	if checkOwnerEntry == nil {
+		return &InsufficientFundsError{UserID: userId, ID: id, Balance: "0", Amount: amount}
+	}
+
+	// Unmarshal the existing data from the ledger
 	var checkOwner OWNERSTRUCT
-	if checkOwnerEntry != nil {
-		// Unmarshal the existing data from the ledger
-		err = json.Unmarshal(checkOwnerEntry, &checkOwner)
-		if err != nil {
-			return fmt.Errorf("failed to unmarshal existing owner entry: %w", err)
-		}
-		// Validate that the owner's balance is sufficient for the removal
-		if checkOwner.Amount < amount {
-			return fmt.Errorf("insufficient balance for owner %s", checkOwner.UserID)
-		}
-		// Update the owner's balance by subtracting the specified amount
-		OwnerStruct.Amount = checkOwner.Amount - amount
-		fmt.Println("Updated owner structure:", OwnerStruct)
+	err = json.Unmarshal(checkOwnerEntry, &checkOwner)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal existing owner entry: %w", err)
+	}
+
+	// Validate that the owner's balance is sufficient for the removal
+	sufficient, err := compareAmounts(checkOwner.Amount, amount)
+	if err != nil {
+		return err
+	}
+	if sufficient < 0 {
+		return &InsufficientFundsError{UserID: userId, ID: id, Balance: checkOwner.Amount, Amount: amount}
 	}
 
+	// Update the owner's balance by subtracting the specified amount
+	OwnerStruct.Amount, err = subAmounts(checkOwner.Amount, amount)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Updated owner structure:", OwnerStruct)
+
 	// Marshal the updated owner structure for storage
 	OwnerAsByte, err := json.Marshal(OwnerStruct)
 	if err != nil {
@@ -637,6 +934,65 @@ func removeBalance(ctx contractapi.TransactionContextInterface, userId string, i
 	return nil
 }
 
+// decreaseSupply lowers the total supply of token id by amount, through a supply delta for
+// delta-write tokens.
+func decreaseSupply(ctx contractapi.TransactionContextInterface, id string, amount AMOUNT) error {
+	deltaWrites, err := usesDeltaWrites(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if deltaWrites {
+		// Record the supply decrease as a delta
+		burnDelta, err := negateAmount(amount)
+		if err != nil {
+			return err
+		}
+
+		return putSupplyDelta(ctx, id, burnDelta)
+	}
+
+	// Retrieve the current total supply
+	forTotalSupply, err := ctx.GetStub().GetState(id)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Current total supply state:", string(forTotalSupply))
+
+	// Ensure total supply is not nil
+	if forTotalSupply == nil {
+		return newError(ERRNOTFOUND, "total supply of token %s does not exist", id).withDetail("id", id)
+	}
+
+	var currFoodie FOODIE
+	// Unmarshal the total supply state
+	err = json.Unmarshal(forTotalSupply, &currFoodie)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal total supply: %w", err)
+	}
+
+	// Decrease the total supply by the burned amount
+	currFoodie.TotalSupply, err = subAmounts(currFoodie.TotalSupply, amount)
+	if err != nil {
+		return wrapError(err, ERRINTERNAL, "failed to update total supply")
+	}
+	fmt.Println("Updated total supply:", currFoodie)
+
+	// Marshal the updated foodie state for storage
+	foodieAsByte, err := json.Marshal(currFoodie)
+	if err != nil {
+		return fmt.Errorf("failed to marshal foodie input: %w", err)
+	}
+
+	// Store the updated foodie state on the ledger
+	err = ctx.GetStub().PutState(currFoodie.ID, foodieAsByte)
+	if err != nil {
+		return fmt.Errorf("failed to store foodie state: %v", err)
+	}
+
+	return nil
+}
+
 func main() {
 
 	chaincode, err := contractapi.NewChaincode(new(SmartContract))
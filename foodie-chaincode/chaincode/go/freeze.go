@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// FREEZESTRUCT records a freeze of account UserId for token Id, or for every token when Id is
+// empty. A frozen account cannot be debited; BlockCredits also stops it from being credited. The
+// record is kept after UnfreezeAccount so the last freeze can still be inspected.
+type FREEZESTRUCT struct {
+	UserID       string `json:"UserId"`
+	ID           string `json:"Id"`
+	Frozen       bool   `json:"Frozen"`
+	ReasonCode   string `json:"ReasonCode"`
+	BlockCredits bool   `json:"BlockCredits"`
+	FrozenBy     string `json:"FrozenBy"`
+	FrozenAt     string `json:"FrozenAt"`
+	UnfrozenBy   string `json:"UnfrozenBy"`
+	UnfrozenAt   string `json:"UnfrozenAt"`
+	DocType      string `json:"DocType"`
+}
+
+// ACCOUNTSTATUS is the freeze state of an account. Frozen is set while any freeze is active;
+// Freezes lists every freeze record of the account, active or not.
+type ACCOUNTSTATUS struct {
+	UserID  string          `json:"UserId"`
+	Frozen  bool            `json:"Frozen"`
+	Freezes []*FREEZESTRUCT `json:"Freezes"`
+}
+
+const FREEZE = "FREEZE"
+const FREEZEINDEX = DOCTYPE + "~Freeze"
+const FREEZEEVENT = "Freeze"
+const UNFREEZEEVENT = "Unfreeze"
+
+const LOSTCARDREASON = "LOST_CARD"
+const INVESTIGATIONREASON = "INVESTIGATION"
+const FRAUDREASON = "FRAUD"
+const OTHERREASON = "OTHER"
+
+var validFreezeReasons = map[string]bool{
+	LOSTCARDREASON:      true,
+	INVESTIGATIONREASON: true,
+	FRAUDREASON:         true,
+	OTHERREASON:         true,
+}
+
+// FreezeAccount blocks debits from account userId for token id, or for every token when id is
+// empty, and also blocks credits when blockCredits is set. An Admin can freeze any account and an
+// account owner can freeze its own account, e.g. after losing a card.
+func (s *SmartContract) FreezeAccount(ctx contractapi.TransactionContextInterface, userId string, id string, reasonCode string, blockCredits bool) error {
+	callerUserID, err := getCallerUserID(ctx)
+	if err != nil {
+		return err
+	}
+	if callerUserID != userId {
+		_, err = assertCallerRole(ctx, ADMINROLE)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !validFreezeReasons[reasonCode] {
+		return newError(ERRINVALIDINPUT, "unknown freeze reason %s", reasonCode)
+	}
+	account, err := getAccount(ctx, userId)
+	if err != nil {
+		return err
+	}
+	if account == nil {
+		return newError(ERRNOTFOUND, "account %s does not exist", userId)
+	}
+	if id != "" {
+		_, err = getRegisteredToken(ctx, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	freeze, err := getFreeze(ctx, userId, id)
+	if err != nil {
+		return err
+	}
+	if freeze.Frozen {
+		return newError(ERRDUPLICATE, "%s is already frozen", freezeScope(userId, id)).withDetail("userId", userId).withDetail("id", id)
+	}
+
+	freeze.Frozen = true
+	freeze.ReasonCode = reasonCode
+	freeze.BlockCredits = blockCredits
+	freeze.FrozenBy = callerUserID
+	freeze.FrozenAt, err = getRecordTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	freeze.UnfrozenBy = ""
+	freeze.UnfrozenAt = ""
+
+	return putFreeze(ctx, freeze, FREEZEEVENT)
+}
+
+// UnfreezeAccount lifts a freeze set by FreezeAccount for the same userId and id. Only an Admin
+// can unfreeze.
+func (s *SmartContract) UnfreezeAccount(ctx contractapi.TransactionContextInterface, userId string, id string) error {
+	admin, err := assertCallerRole(ctx, ADMINROLE)
+	if err != nil {
+		return err
+	}
+
+	freeze, err := getFreeze(ctx, userId, id)
+	if err != nil {
+		return err
+	}
+	if !freeze.Frozen {
+		return newError(ERRNOTFOUND, "%s is not frozen", freezeScope(userId, id)).withDetail("userId", userId).withDetail("id", id)
+	}
+
+	freeze.Frozen = false
+	freeze.UnfrozenBy = admin
+	freeze.UnfrozenAt, err = getRecordTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	return putFreeze(ctx, freeze, UNFREEZEEVENT)
+}
+
+// GetAccountStatus returns the freeze state of account userId. Only the account owner, an Admin or
+// an Auditor can read it.
+func (s *SmartContract) GetAccountStatus(ctx contractapi.TransactionContextInterface, userId string) (*ACCOUNTSTATUS, error) {
+	err := assertAccountOwner(ctx, userId)
+	if err != nil {
+		_, err = assertCallerRole(ctx, ADMINROLE, AUDITORROLE)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(FREEZEINDEX, []string{userId})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	status := &ACCOUNTSTATUS{UserID: userId, Freezes: []*FREEZESTRUCT{}}
+	for resultsIterator.HasNext() {
+		queryResult, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var freeze FREEZESTRUCT
+		err = json.Unmarshal(queryResult.Value, &freeze)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal freeze: %w", err)
+		}
+		status.Freezes = append(status.Freezes, &freeze)
+		status.Frozen = status.Frozen || freeze.Frozen
+	}
+
+	return status, nil
+}
+
+func freezeScope(userId string, id string) string {
+	if id == "" {
+		return "account " + userId
+	}
+	return "account " + userId + " for token " + id
+}
+
+func freezeKey(ctx contractapi.TransactionContextInterface, userId string, id string) (string, error) {
+	attributes := []string{userId}
+	if id != "" {
+		attributes = append(attributes, id)
+	}
+
+	freezeKey, err := ctx.GetStub().CreateCompositeKey(FREEZEINDEX, attributes)
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key for freeze: %w", err)
+	}
+
+	return freezeKey, nil
+}
+
+// getFreeze reads the freeze record of userId for id, returning an unfrozen record if there is
+// none.
+func getFreeze(ctx contractapi.TransactionContextInterface, userId string, id string) (*FREEZESTRUCT, error) {
+	freezeKey, err := freezeKey(ctx, userId, id)
+	if err != nil {
+		return nil, err
+	}
+
+	freezeAsByte, err := ctx.GetStub().GetState(freezeKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch freeze: %w", err)
+	}
+
+	freeze := FREEZESTRUCT{UserID: userId, ID: id, DocType: FREEZE}
+	if freezeAsByte != nil {
+		err = json.Unmarshal(freezeAsByte, &freeze)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal freeze: %w", err)
+		}
+	}
+
+	return &freeze, nil
+}
+
+// putFreeze stores freeze and emits it as eventType.
+func putFreeze(ctx contractapi.TransactionContextInterface, freeze *FREEZESTRUCT, eventType string) error {
+	freezeKey, err := freezeKey(ctx, freeze.UserID, freeze.ID)
+	if err != nil {
+		return err
+	}
+
+	freezeAsByte, err := json.Marshal(freeze)
+	if err != nil {
+		return fmt.Errorf("failed to marshal freeze: %w", err)
+	}
+
+	err = ctx.GetStub().PutState(freezeKey, freezeAsByte)
+	if err != nil {
+		return fmt.Errorf("failed to store freeze state: %v", err)
+	}
+
+	// Emit the change in the same envelope as balance events
+	event, err := newEvent(ctx, eventType, "", []EVENTLEG{})
+	if err != nil {
+		return err
+	}
+	event.ID = freeze.ID
+	event.Freeze = freeze
+
+	return setEvent(ctx, event)
+}
+
+// assertNotFrozen fails with FROZEN when account userId is closed, or frozen for token id or for
+// every token. Credits are only refused by freezes that block credits.
+func assertNotFrozen(ctx contractapi.TransactionContextInterface, userId string, id string, credit bool) error {
+	account, err := getAccount(ctx, userId)
+	if err != nil {
+		return err
+	}
+	if account != nil && account.Closed {
+		return newError(ERRFROZEN, "account %s is closed, its balances were moved to %s", userId, account.SuccessorUserID).
+			withDetail("userId", userId).
+			withDetail("successorUserId", account.SuccessorUserID)
+	}
+
+	for _, scope := range []string{"", id} {
+		freeze, err := getFreeze(ctx, userId, scope)
+		if err != nil {
+			return err
+		}
+		if freeze.Frozen && (!credit || freeze.BlockCredits) {
+			return newError(ERRFROZEN, "%s is frozen: %s", freezeScope(userId, scope), freeze.ReasonCode).
+				withDetail("userId", userId).
+				withDetail("id", scope).
+				withDetail("reasonCode", freeze.ReasonCode)
+		}
+	}
+
+	return nil
+}
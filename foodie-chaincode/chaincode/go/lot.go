@@ -0,0 +1,445 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// LOTSTRUCT is the part of an Expiring token balance that lapses at ExpiresAt. TxnId is the mint
+// the lot came from; a lot keeps its mint and expiry when it moves between accounts. Lots are keyed
+// by expiry so an owner's lots are read earliest-expiring first.
+type LOTSTRUCT struct {
+	ID        string `json:"Id"`
+	Owner     string `json:"Owner"`
+	ExpiresAt string `json:"ExpiresAt"`
+	TxnID     string `json:"TxnId"`
+	DocType   string `json:"DocType"`
+	Amount    AMOUNT `json:"Amount"`
+}
+
+// EXPIRYSUMMARY is the result of ExpireLots. Expired lists the amount burned per account.
+type EXPIRYSUMMARY struct {
+	ID           string     `json:"Id"`
+	AsOf         string     `json:"AsOf"`
+	TxnID        string     `json:"TxnId"`
+	TotalExpired AMOUNT     `json:"TotalExpired"`
+	Expired      []EVENTLEG `json:"Expired"`
+}
+
+const LOT = "LOT"
+const LOTINDEX = DOCTYPE + "~Lot"
+const EXPIREEVENT = "Expire"
+const EXPIREDREASON = "expired"
+
+// ListLots returns the lots of owner for token id, earliest-expiring first. Expired lots are listed
+// until ExpireLots burns them.
+func (s *SmartContract) ListLots(ctx contractapi.TransactionContextInterface, owner string, id string) ([]*LOTSTRUCT, error) {
+	// Only the account owner, an Admin or an Auditor can list lots
+	err := assertAccountOwner(ctx, owner)
+	if err != nil {
+		_, err = assertCallerRole(ctx, ADMINROLE, AUDITORROLE)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	token, err := getRegisteredToken(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Expiring {
+		return nil, newError(ERRINVALIDINPUT, "token %s does not expire", id)
+	}
+
+	return getLots(ctx, []string{id, owner})
+}
+
+// ExpireLots burns every lot of token id that expired at or before asOf (RFC3339, the transaction
+// time when empty) and lowers the total supply. Each account's expired amount is recorded as a
+// BURNTXN with reason "expired" under TxnId <Fabric transaction id>#<n>. Expiry applies to frozen
+// accounts too. Only an Admin or a Burner can expire lots.
+func (s *SmartContract) ExpireLots(ctx contractapi.TransactionContextInterface, id string, asOf string) (*EXPIRYSUMMARY, error) {
+	burner, err := assertCallerRole(ctx, ADMINROLE, BURNERROLE)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := getRegisteredToken(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Expiring {
+		return nil, newError(ERRINVALIDINPUT, "token %s does not expire", id)
+	}
+	err = assertNotPaused(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	timestamp, err := getRecordTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if asOf == "" {
+		asOf = timestamp
+	}
+	asOf, err = parseRecordTime(asOf)
+	if err != nil {
+		return nil, err
+	}
+	if asOf > timestamp {
+		return nil, newError(ERRINVALIDINPUT, "lots cannot be expired as of %s, which is after the transaction time %s", asOf, timestamp)
+	}
+
+	summary := &EXPIRYSUMMARY{ID: id, AsOf: asOf, TxnID: ctx.GetStub().GetTxID(), TotalExpired: "0", Expired: []EVENTLEG{}}
+
+	// Delete the expired lots, adding up the expired amount of each owner
+	lots, err := getLots(ctx, []string{id})
+	if err != nil {
+		return nil, err
+	}
+
+	expired := make(map[string]AMOUNT)
+	for _, lot := range lots {
+		if lot.ExpiresAt > asOf {
+			continue
+		}
+
+		err = putLot(ctx, lot.Owner, &LOTSTRUCT{ID: id, ExpiresAt: lot.ExpiresAt, TxnID: lot.TxnID, Amount: "0"})
+		if err != nil {
+			return nil, err
+		}
+		expired[lot.Owner], err = addAmounts(expired[lot.Owner], lot.Amount)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(expired) == 0 {
+		return summary, nil
+	}
+
+	// Burn the expired amount of each owner, in a fixed order so every endorser produces the same
+	// write set
+	indexName := "TxnID~" + DOCTYPE
+	for i, owner := range sortedKeys(expired) {
+		err = debitBalance(ctx, owner, id, expired[owner])
+		if err != nil {
+			return nil, err
+		}
+
+		var burntxn BURNTXN
+		burntxn.ID = id
+		burntxn.UserID = burner
+		burntxn.BurnTokenID = owner
+		burntxn.DocType = BURN
+		burntxn.TxnID = legTxnID(summary.TxnID, i)
+		burntxn.BurnTokenAmount = expired[owner]
+		burntxn.Reason = EXPIREDREASON
+		burntxn.Timestamp = timestamp
+
+		TxnCompositeKey, err := ctx.GetStub().CreateCompositeKey(indexName, []string{burntxn.TxnID, burntxn.ID})
+		if err != nil {
+			return nil, err
+		}
+
+		TXNAsByte, err := json.Marshal(burntxn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal transaction: %w", err)
+		}
+
+		err = ctx.GetStub().PutState(TxnCompositeKey, TXNAsByte)
+		if err != nil {
+			return nil, fmt.Errorf("failed to store transaction state: %v", err)
+		}
+
+		err = indexTxn(ctx, TxnCompositeKey, burntxn.ID, burntxn.BurnTokenID, "", burntxn.Timestamp, burntxn.TxnID)
+		if err != nil {
+			return nil, err
+		}
+
+		summary.TotalExpired, err = addAmounts(summary.TotalExpired, expired[owner])
+		if err != nil {
+			return nil, err
+		}
+		summary.Expired = append(summary.Expired, EVENTLEG{ID: id, From: owner, Amount: expired[owner]})
+	}
+
+	err = decreaseSupply(ctx, id, summary.TotalExpired)
+	if err != nil {
+		return nil, err
+	}
+
+	err = emitEvent(ctx, EXPIREEVENT, summary.TxnID, summary.Expired)
+	if err != nil {
+		return nil, err
+	}
+
+	return summary, nil
+}
+
+// assertTokenValid fails when the transaction time is outside the validity window of token.
+func assertTokenValid(ctx contractapi.TransactionContextInterface, token *TOKENDEF) error {
+	if token.ValidFrom == "" && token.ValidUntil == "" {
+		return nil
+	}
+
+	timestamp, err := getRecordTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	if (token.ValidFrom != "" && timestamp < token.ValidFrom) || (token.ValidUntil != "" && timestamp >= token.ValidUntil) {
+		return newError(ERRINVALIDINPUT, "token %s is not valid at %s", token.ID, timestamp).
+			withDetail("id", token.ID).
+			withDetail("validFrom", token.ValidFrom).
+			withDetail("validUntil", token.ValidUntil)
+	}
+
+	return nil
+}
+
+// lotExpiry returns the expiry of a lot minted of token with the requested expiresAt, which
+// defaults to the end of the token's validity window. Tokens that do not expire take no expiry.
+func lotExpiry(ctx contractapi.TransactionContextInterface, token *TOKENDEF, expiresAt string) (string, error) {
+	if !token.Expiring {
+		if expiresAt != "" {
+			return "", newError(ERRINVALIDINPUT, "token %s does not expire", token.ID)
+		}
+		return "", nil
+	}
+
+	if expiresAt == "" {
+		expiresAt = token.ValidUntil
+	}
+	if expiresAt == "" {
+		return "", newError(ERRINVALIDINPUT, "an expiry is required to mint token %s", token.ID)
+	}
+	expiresAt, err := parseRecordTime(expiresAt)
+	if err != nil {
+		return "", err
+	}
+
+	timestamp, err := getRecordTimestamp(ctx)
+	if err != nil {
+		return "", err
+	}
+	if expiresAt <= timestamp {
+		return "", newError(ERRINVALIDINPUT, "expiry %s is not in the future", expiresAt)
+	}
+	if token.ValidUntil != "" && expiresAt > token.ValidUntil {
+		return "", newError(ERRINVALIDINPUT, "expiry %s is after token %s stops being valid at %s", expiresAt, token.ID, token.ValidUntil)
+	}
+
+	return expiresAt, nil
+}
+
+// getLots reads every lot under the partial lot key attributes ([id] or [id, owner]).
+func getLots(ctx contractapi.TransactionContextInterface, attributes []string) ([]*LOTSTRUCT, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(LOTINDEX, attributes)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	lots := []*LOTSTRUCT{}
+	for resultsIterator.HasNext() {
+		queryResult, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var lot LOTSTRUCT
+		err = json.Unmarshal(queryResult.Value, &lot)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal lot: %w", err)
+		}
+		lots = append(lots, &lot)
+	}
+
+	return lots, nil
+}
+
+// putLot stores lot under owner, deleting it when its amount is zero.
+func putLot(ctx contractapi.TransactionContextInterface, owner string, lot *LOTSTRUCT) error {
+	lotKey, err := ctx.GetStub().CreateCompositeKey(LOTINDEX, []string{lot.ID, owner, lot.ExpiresAt, lot.TxnID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key for lot: %w", err)
+	}
+
+	if lot.Amount == "0" {
+		err = ctx.GetStub().DelState(lotKey)
+		if err != nil {
+			return fmt.Errorf("failed to delete lot state: %v", err)
+		}
+		return nil
+	}
+
+	stored := *lot
+	stored.Owner = owner
+	stored.DocType = LOT
+
+	lotAsByte, err := json.Marshal(stored)
+	if err != nil {
+		return fmt.Errorf("failed to marshal lot: %w", err)
+	}
+
+	err = ctx.GetStub().PutState(lotKey, lotAsByte)
+	if err != nil {
+		return fmt.Errorf("failed to store lot state: %v", err)
+	}
+
+	return nil
+}
+
+// spendLots takes amount of token id from the lots of owner, earliest-expiring first, and returns
+// the parts taken. Expired lots are skipped unless includeExpired is set. Tokens that do not expire
+// have no lots and return nil.
+func spendLots(ctx contractapi.TransactionContextInterface, owner string, id string, amount AMOUNT, includeExpired bool) ([]*LOTSTRUCT, error) {
+	token, err := getToken(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if token == nil || !token.Expiring {
+		return nil, nil
+	}
+
+	remaining, err := parseAmount(amount)
+	if err != nil {
+		return nil, err
+	}
+
+	timestamp, err := getRecordTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	lots, err := getLots(ctx, []string{id, owner})
+	if err != nil {
+		return nil, err
+	}
+
+	var spent []*LOTSTRUCT
+	for _, lot := range lots {
+		if remaining.Sign() == 0 {
+			break
+		}
+		if !includeExpired && lot.ExpiresAt <= timestamp {
+			continue
+		}
+
+		value, err := parseAmount(lot.Amount)
+		if err != nil {
+			return nil, err
+		}
+		taken := value
+		if taken.Cmp(remaining) > 0 {
+			taken = new(big.Int).Set(remaining)
+		}
+		remaining.Sub(remaining, taken)
+
+		part := &LOTSTRUCT{ID: id, ExpiresAt: lot.ExpiresAt, TxnID: lot.TxnID, Amount: toAmount(taken)}
+		spent = append(spent, part)
+
+		lot.Amount, err = subAmounts(lot.Amount, part.Amount)
+		if err != nil {
+			return nil, err
+		}
+		err = putLot(ctx, owner, lot)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if remaining.Sign() > 0 {
+		available, err := subAmounts(amount, toAmount(remaining))
+		if err != nil {
+			return nil, err
+		}
+		return nil, &InsufficientFundsError{UserID: owner, ID: id, Balance: available, Amount: amount}
+	}
+
+	return spent, nil
+}
+
+// creditLots adds lots to the lots of owner, merging parts of the same mint and expiry.
+func creditLots(ctx contractapi.TransactionContextInterface, owner string, lots []*LOTSTRUCT) error {
+	for _, lot := range lots {
+		lotKey, err := ctx.GetStub().CreateCompositeKey(LOTINDEX, []string{lot.ID, owner, lot.ExpiresAt, lot.TxnID})
+		if err != nil {
+			return fmt.Errorf("failed to create composite key for lot: %w", err)
+		}
+
+		lotAsByte, err := ctx.GetStub().GetState(lotKey)
+		if err != nil {
+			return fmt.Errorf("failed to fetch lot: %w", err)
+		}
+
+		credited := *lot
+		if lotAsByte != nil {
+			var existing LOTSTRUCT
+			err = json.Unmarshal(lotAsByte, &existing)
+			if err != nil {
+				return fmt.Errorf("failed to unmarshal lot: %w", err)
+			}
+			credited.Amount, err = addAmounts(existing.Amount, lot.Amount)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = putLot(ctx, owner, &credited)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// moveLots moves amount of token id from the unexpired lots of from to to.
+func moveLots(ctx contractapi.TransactionContextInterface, from string, to string, id string, amount AMOUNT) error {
+	lots, err := spendLots(ctx, from, id, amount, false)
+	if err != nil {
+		return err
+	}
+
+	return creditLots(ctx, to, lots)
+}
+
+// splitLots takes amount from the front of lots, splitting a lot if needed, and returns the parts
+// taken and the lots left over.
+func splitLots(lots []*LOTSTRUCT, amount AMOUNT) ([]*LOTSTRUCT, []*LOTSTRUCT, error) {
+	remaining, err := parseAmount(amount)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var taken []*LOTSTRUCT
+	for len(lots) > 0 && remaining.Sign() > 0 {
+		lot := lots[0]
+		value, err := parseAmount(lot.Amount)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if value.Cmp(remaining) <= 0 {
+			taken = append(taken, lot)
+			lots = lots[1:]
+			remaining.Sub(remaining, value)
+			continue
+		}
+
+		part := *lot
+		part.Amount = toAmount(remaining)
+		taken = append(taken, &part)
+		rest := *lot
+		rest.Amount = toAmount(value.Sub(value, remaining))
+		lots = append([]*LOTSTRUCT{&rest}, lots[1:]...)
+		remaining.SetInt64(0)
+	}
+
+	return taken, lots, nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestSplitLotsTakesFromTheFront(t *testing.T) {
+	lots := []*LOTSTRUCT{
+		{ID: "MEAL", ExpiresAt: "2025-01-31T00:00:00Z", TxnID: "m1", Amount: "4"},
+		{ID: "MEAL", ExpiresAt: "2025-06-30T00:00:00Z", TxnID: "m2", Amount: "5"},
+	}
+
+	taken, rest, err := splitLots(lots, "6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(taken) != 2 || taken[0].TxnID != "m1" || taken[0].Amount != "4" || taken[1].TxnID != "m2" || taken[1].Amount != "2" {
+		t.Fatalf("unexpected lots taken: %+v %+v", taken[0], taken[1])
+	}
+	if len(rest) != 1 || rest[0].TxnID != "m2" || rest[0].Amount != "3" {
+		t.Fatalf("unexpected lots left: %+v", rest)
+	}
+	if lots[1].Amount != "5" {
+		t.Fatalf("expected the input lots to be left untouched, got %s", lots[1].Amount)
+	}
+
+	taken, rest, err = splitLots(rest, "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(taken) != 1 || taken[0].Amount != "3" || len(rest) != 0 {
+		t.Fatalf("expected the last lot to be taken whole, got %v and %v", taken, rest)
+	}
+}
+
+// runAt runs fn as identity with the transaction time set to at.
+func (l *balanceTestLedger) runAt(identity *testIdentity, at time.Time, fn func(ctx contractapi.TransactionContextInterface) error) error {
+	return l.runAs(identity, func(ctx contractapi.TransactionContextInterface) error {
+		l.stub.TxTimestamp = timestamppb.New(at)
+		return fn(ctx)
+	})
+}
+
+// lots lists the lots of owner for token id as TxnId:Amount, earliest-expiring first.
+func (l *balanceTestLedger) lots(owner string, id string) []string {
+	l.t.Helper()
+	var listed []string
+	err := l.run(func(ctx contractapi.TransactionContextInterface) error {
+		lots, err := getLots(ctx, []string{id, owner})
+		for _, lot := range lots {
+			listed = append(listed, lot.TxnID+":"+string(lot.Amount))
+		}
+		return err
+	})
+	if err != nil {
+		l.t.Fatal(err)
+	}
+	return listed
+}
+
+func TestExpiringLotsAreSpentEarliestFirstAndBurnedOnExpiry(t *testing.T) {
+	ledger := newBalanceTestLedger(t)
+	ledger.createToken(TOKENDEF{ID: "MEAL", IssuerOrg: "Org1MSP", Transferable: true, Expiring: true, DocType: TOKEN})
+	ledger.registerAccount("admin", ADMINROLE)
+	ledger.registerAccount("minter", MINTERROLE)
+	ledger.registerAccount("alice", STUDENTROLE)
+	ledger.registerAccount("bob", STUDENTROLE)
+
+	now := time.Now().UTC()
+	expiry := func(hours int) string {
+		return now.Add(time.Duration(hours) * time.Hour).Format(time.RFC3339)
+	}
+	for _, mint := range []FOODIE{
+		{TxnID: "m1", ID: "MEAL", UserId: "alice", Amount: "20", ExpiresAt: expiry(2)},
+		{TxnID: "m2", ID: "MEAL", UserId: "alice", Amount: "10", ExpiresAt: expiry(1)},
+		{TxnID: "m3", ID: "MEAL", UserId: "bob", Amount: "5", ExpiresAt: expiry(3)},
+	} {
+		if _, err := ledger.mint("minter", mint); err != nil {
+			t.Fatal(err)
+		}
+	}
+	transfer := func(at time.Time, txnId string, from string, to string, amount AMOUNT) error {
+		return ledger.runAt(newTestIdentity(from), at, func(ctx contractapi.TransactionContextInterface) error {
+			_, err := new(SmartContract).TransferTyped(ctx, TRANSFER{TxnID: txnId, ID: "MEAL", UserId: from, Receiver: to, Amount: amount})
+			return err
+		})
+	}
+	supply := func() AMOUNT {
+		var supply AMOUNT
+		err := ledger.run(func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			supply, err = getTotalSupply(ctx, "MEAL")
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return supply
+	}
+
+	// A transfer takes the earliest-expiring lots first and the receiver keeps their expiry
+	if err := transfer(now, "t1", "alice", "bob", "12"); err != nil {
+		t.Fatal(err)
+	}
+	if lots := ledger.lots("alice", "MEAL"); len(lots) != 1 || lots[0] != "m1:18" {
+		t.Fatalf("unexpected lots of alice: %v", lots)
+	}
+	if lots := ledger.lots("bob", "MEAL"); len(lots) != 3 || lots[0] != "m2:10" || lots[1] != "m1:2" || lots[2] != "m3:5" {
+		t.Fatalf("unexpected lots of bob: %v", lots)
+	}
+
+	// Once lots expire only the unexpired ones are handed on
+	later := now.Add(150 * time.Minute)
+	if err := transfer(later, "t3", "bob", "alice", "5"); err != nil {
+		t.Fatal(err)
+	}
+	if lots := ledger.lots("alice", "MEAL"); len(lots) != 2 || lots[0] != "m1:18" || lots[1] != "m3:5" {
+		t.Fatalf("expected alice to receive only the unexpired lot, got %v", lots)
+	}
+
+	// ExpireLots burns only the lots expired as of asOf
+	var summary *EXPIRYSUMMARY
+	err := ledger.runAt(newTestIdentity("admin"), later, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		summary, err = new(SmartContract).ExpireLots(ctx, "MEAL", now.Add(90*time.Minute).Format(time.RFC3339))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.TotalExpired != "10" || len(summary.Expired) != 1 || summary.Expired[0].From != "bob" || summary.Expired[0].Amount != "10" {
+		t.Fatalf("unexpected expiry summary: %+v", summary)
+	}
+	if lots := ledger.lots("bob", "MEAL"); len(lots) != 1 || lots[0] != "m1:2" {
+		t.Fatalf("expected only the expired lot of bob to be burned, got %v", lots)
+	}
+	if lots := ledger.lots("alice", "MEAL"); len(lots) != 2 {
+		t.Fatalf("expected the lots of alice to be kept, got %v", lots)
+	}
+	if balance := ledger.balance("bob", "MEAL"); balance != "2" {
+		t.Fatalf("expected bob to keep 2, got %s", balance)
+	}
+	if balance := ledger.balance("alice", "MEAL"); balance != "23" {
+		t.Fatalf("expected alice to keep 23, got %s", balance)
+	}
+	if total := supply(); total != "25" {
+		t.Fatalf("expected supply 25, got %s", total)
+	}
+
+	// The burn is recorded per account under the leg TxnId with reason expired
+	burnKey, err := ledger.stub.CreateCompositeKey("TxnID~"+DOCTYPE, []string{legTxnID(summary.TxnID, 0), "MEAL"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var burn BURNTXN
+	if err := json.Unmarshal(ledger.stub.State[burnKey], &burn); err != nil {
+		t.Fatal(err)
+	}
+	if burn.DocType != BURN || burn.BurnTokenID != "bob" || burn.BurnTokenAmount != "10" || burn.Reason != EXPIREDREASON || burn.UserID != "admin" {
+		t.Fatalf("unexpected burn record: %+v", burn)
+	}
+
+	// Expired lots cannot back a transfer, even before ExpireLots burns them
+	assertInsufficientFunds(t, transfer(later, "t4", "alice", "bob", "6"), "5")
+}
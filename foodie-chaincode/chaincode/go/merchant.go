@@ -0,0 +1,408 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// MERCHANTSTRUCT registers a cafeteria or shop that students can Pay. Payments to the merchant
+// are credited to SettlementAccount, an account holding the Merchant role.
+type MERCHANTSTRUCT struct {
+	MerchantID        string `json:"MerchantId"`
+	OwnerOrg          string `json:"OwnerOrg"`
+	DisplayName       string `json:"DisplayName"`
+	Category          string `json:"Category"`
+	SettlementAccount string `json:"SettlementAccount"`
+	RegisteredBy      string `json:"RegisteredBy"`
+	RegisteredAt      string `json:"RegisteredAt"`
+	DocType           string `json:"DocType"`
+}
+
+// LINEITEM is one line of an order paid with Pay.
+type LINEITEM struct {
+	Description string `json:"Description"`
+	Quantity    int    `json:"Quantity"`
+	UnitPrice   AMOUNT `json:"UnitPrice"`
+}
+
+// PAYMENT is the Pay payload. Total must equal the sum of Quantity times UnitPrice over Items.
+type PAYMENT struct {
+	TxnID      string     `json:"TxnId"`
+	ID         string     `json:"Id"`
+	UserId     string     `json:"UserId"`
+	MerchantID string     `json:"MerchantId"`
+	OrderRef   string     `json:"OrderRef"`
+	Items      []LINEITEM `json:"Items"`
+	Total      AMOUNT     `json:"Total"`
+}
+
+// SALE is the record stored for every Pay: the order and the transfer of Total from UserId to the
+// merchant's settlement account (Receiver). Refunded and Refunds are set once the sale is refunded.
+type SALE struct {
+	TxnID      string     `json:"TxnId"`
+	ID         string     `json:"Id"`
+	DocType    string     `json:"DocType"`
+	UserId     string     `json:"UserId"`
+	MerchantID string     `json:"MerchantId"`
+	Receiver   string     `json:"Receiver"`
+	OrderRef   string     `json:"OrderRef"`
+	Items      []LINEITEM `json:"Items"`
+	Total      AMOUNT     `json:"Total"`
+	Timestamp  string     `json:"Timestamp"`
+	Refunded   AMOUNT     `json:"Refunded,omitempty" metadata:"Refunded,optional"`
+	Refunds    []string   `json:"Refunds,omitempty" metadata:"Refunds,optional"`
+}
+
+const MERCHANT = "MERCHANT"
+const MERCHANTINDEX = DOCTYPE + "~Merchant"
+const SALEINDEX = DOCTYPE + "~Sale"
+const PURCHASEINDEX = DOCTYPE + "~Purchase"
+const PAYTXN = "PAYTXN"
+const PAYEVENT = "Pay"
+
+// RegisterMerchant registers merchant merchantId owned by org ownerOrg, which defaults to the
+// caller's MSPID. settlementAccount must be an open account holding the Merchant role. Only an
+// Admin can register merchants.
+func (s *SmartContract) RegisterMerchant(ctx contractapi.TransactionContextInterface, merchantId string, ownerOrg string, displayName string, category string, settlementAccount string) error {
+	admin, err := assertCallerRole(ctx, ADMINROLE)
+	if err != nil {
+		return err
+	}
+
+	var v inputValidator
+	v.requireID("MerchantId", merchantId)
+	v.optionalID("OwnerOrg", ownerOrg)
+	v.requireText("DisplayName", displayName)
+	v.requireText("Category", category)
+	v.requireID("SettlementAccount", settlementAccount)
+	err = v.err()
+	if err != nil {
+		return err
+	}
+
+	existingMerchant, err := getMerchant(ctx, merchantId)
+	if err != nil {
+		return err
+	}
+	if existingMerchant != nil {
+		return newError(ERRDUPLICATE, "merchant %s is already registered", merchantId)
+	}
+
+	// Ensure the settlement account can receive payments
+	account, err := getAccount(ctx, settlementAccount)
+	if err != nil {
+		return err
+	}
+	if account == nil {
+		return newError(ERRNOTFOUND, "account %s does not exist", settlementAccount)
+	}
+	if account.Closed {
+		return newError(ERRINVALIDINPUT, "account %s is closed", settlementAccount).withDetail("userId", settlementAccount)
+	}
+	isMerchant, err := hasRole(ctx, settlementAccount, MERCHANTROLE)
+	if err != nil {
+		return err
+	}
+	if !isMerchant {
+		return newError(ERRINVALIDINPUT, "settlement account %s does not hold the %s role", settlementAccount, MERCHANTROLE)
+	}
+
+	if ownerOrg == "" {
+		ownerOrg, err = ctx.GetClientIdentity().GetMSPID()
+		if err != nil {
+			return fmt.Errorf("failed to get MSPID: %w", err)
+		}
+	}
+
+	var merchant MERCHANTSTRUCT
+	merchant.MerchantID = merchantId
+	merchant.OwnerOrg = ownerOrg
+	merchant.DisplayName = displayName
+	merchant.Category = category
+	merchant.SettlementAccount = settlementAccount
+	merchant.RegisteredBy = admin
+	merchant.DocType = MERCHANT
+	merchant.RegisteredAt, err = getRecordTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	merchantKey, err := ctx.GetStub().CreateCompositeKey(MERCHANTINDEX, []string{merchantId})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key for merchant: %w", err)
+	}
+
+	merchantAsByte, err := json.Marshal(merchant)
+	if err != nil {
+		return fmt.Errorf("failed to marshal merchant: %w", err)
+	}
+
+	err = ctx.GetStub().PutState(merchantKey, merchantAsByte)
+	if err != nil {
+		return fmt.Errorf("failed to store merchant state: %v", err)
+	}
+
+	return nil
+}
+
+// GetMerchant returns the registration of merchant merchantId.
+func (s *SmartContract) GetMerchant(ctx contractapi.TransactionContextInterface, merchantId string) (*MERCHANTSTRUCT, error) {
+	return getRegisteredMerchant(ctx, merchantId)
+}
+
+// Pay moves Total of token Id from UserId to the settlement account of merchant MerchantId and
+// stores the order as a PAYTXN sale, listed by ListSales and ListPurchases. input is passed as a
+// JSON object whose schema is published in the contract metadata. Unlike Transfer, Pay also
+// accepts tokens that are not transferable, since it only pays registered merchants. Retries are
+// handled like Mint.
+func (s *SmartContract) Pay(ctx contractapi.TransactionContextInterface, input PAYMENT) (*RECEIPT, error) {
+	err := validatePayInput(&input)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("Unmarshaled input data:", input)
+
+	// Ensure the caller is a Student and owns the account being debited
+	_, err = assertCallerRole(ctx, STUDENTROLE)
+	if err != nil {
+		return nil, err
+	}
+
+	err = assertAccountOwner(ctx, input.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	// Return the original receipt if this request was already applied
+	receipt, requestHash, err := checkReceipt(ctx, input.TxnID, PAYEVENT, &input)
+	if err != nil {
+		return nil, err
+	}
+	if receipt != nil {
+		return receipt, nil
+	}
+
+	// Refuse the request while the contract or the token is paused
+	err = assertNotPaused(ctx, input.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ensure the token and the merchant are registered
+	_, err = getRegisteredToken(ctx, input.ID)
+	if err != nil {
+		return nil, err
+	}
+	merchant, err := getRegisteredMerchant(ctx, input.MerchantID)
+	if err != nil {
+		return nil, err
+	}
+	if merchant.SettlementAccount == input.UserId {
+		return nil, newError(ERRINVALIDINPUT, "account %s cannot pay its own merchant %s", input.UserId, merchant.MerchantID)
+	}
+
+	var sale SALE
+	sale.TxnID = input.TxnID
+	sale.ID = input.ID
+	sale.DocType = PAYTXN
+	sale.UserId = input.UserId
+	sale.MerchantID = merchant.MerchantID
+	sale.Receiver = merchant.SettlementAccount
+	sale.OrderRef = input.OrderRef
+	sale.Items = input.Items
+	sale.Total = input.Total
+	sale.Timestamp, err = getRecordTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create a composite key for the transaction
+	indexName := "TxnID~" + DOCTYPE
+	TxnCompositeKey, err := ctx.GetStub().CreateCompositeKey(indexName, []string{sale.TxnID, sale.ID})
+	if err != nil {
+		return nil, err
+	}
+
+	// Check for duplicate transactions
+	checkTxnDuplication, err := ctx.GetStub().GetState(TxnCompositeKey)
+	if err != nil {
+		return nil, fmt.Errorf("error checking transaction duplication: %w", err)
+	}
+	if checkTxnDuplication != nil {
+		return nil, newError(ERRDUPLICATE, "duplicate transaction")
+	}
+
+	// Move the total to the merchant's settlement account
+	err = removeBalance(ctx, sale.UserId, sale.ID, sale.Total)
+	if err != nil {
+		return nil, err
+	}
+
+	err = addBalance(ctx, sale.Receiver, sale.ID, sale.Total)
+	if err != nil {
+		return nil, err
+	}
+
+	err = moveLots(ctx, sale.UserId, sale.Receiver, sale.ID, sale.Total)
+	if err != nil {
+		return nil, err
+	}
+
+	saleAsByte, err := json.Marshal(sale)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal sale: %w", err)
+	}
+
+	err = ctx.GetStub().PutState(TxnCompositeKey, saleAsByte)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store composite key state: %v", err)
+	}
+
+	// Index the payment under both accounts, the merchant's sales and the student's purchases
+	err = indexTxn(ctx, TxnCompositeKey, sale.ID, sale.UserId, sale.Receiver, sale.Timestamp, sale.TxnID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = indexSale(ctx, TxnCompositeKey, SALEINDEX, sale.MerchantID, sale.Timestamp, sale.TxnID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = indexSale(ctx, TxnCompositeKey, PURCHASEINDEX, sale.UserId, sale.Timestamp, sale.TxnID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Emit the payment event
+	legs := []EVENTLEG{{ID: sale.ID, From: sale.UserId, To: sale.Receiver, Amount: sale.Total}}
+	err = emitEvent(ctx, PAYEVENT, sale.TxnID, legs)
+	if err != nil {
+		return nil, err
+	}
+
+	return putReceipt(ctx, input.TxnID, PAYEVENT, requestHash, legs)
+}
+
+// ListSales returns the itemised sales of merchant merchantId, oldest first. Only the owner of the
+// merchant's settlement account, an Admin or an Auditor can list them.
+func (s *SmartContract) ListSales(ctx contractapi.TransactionContextInterface, merchantId string) ([]*SALE, error) {
+	merchant, err := getRegisteredMerchant(ctx, merchantId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = assertAccountOwner(ctx, merchant.SettlementAccount)
+	if err != nil {
+		_, err = assertCallerRole(ctx, ADMINROLE, AUDITORROLE)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return getSales(ctx, SALEINDEX, merchantId)
+}
+
+// ListPurchases returns the itemised purchases paid with Pay from account userId, oldest first.
+// Only the account owner, an Admin or an Auditor can list them.
+func (s *SmartContract) ListPurchases(ctx contractapi.TransactionContextInterface, userId string) ([]*SALE, error) {
+	err := assertAccountOwner(ctx, userId)
+	if err != nil {
+		_, err = assertCallerRole(ctx, ADMINROLE, AUDITORROLE)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return getSales(ctx, PURCHASEINDEX, userId)
+}
+
+// getMerchant reads the registration of merchantId, returning nil if there is none.
+func getMerchant(ctx contractapi.TransactionContextInterface, merchantId string) (*MERCHANTSTRUCT, error) {
+	merchantKey, err := ctx.GetStub().CreateCompositeKey(MERCHANTINDEX, []string{merchantId})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key for merchant: %w", err)
+	}
+
+	merchantAsByte, err := ctx.GetStub().GetState(merchantKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch merchant: %w", err)
+	}
+	if merchantAsByte == nil {
+		return nil, nil
+	}
+
+	var merchant MERCHANTSTRUCT
+	err = json.Unmarshal(merchantAsByte, &merchant)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal merchant: %w", err)
+	}
+
+	return &merchant, nil
+}
+
+// getRegisteredMerchant is getMerchant but fails for unknown merchant ids.
+func getRegisteredMerchant(ctx contractapi.TransactionContextInterface, merchantId string) (*MERCHANTSTRUCT, error) {
+	merchant, err := getMerchant(ctx, merchantId)
+	if err != nil {
+		return nil, err
+	}
+	if merchant == nil {
+		return nil, newError(ERRNOTFOUND, "merchant %s is not registered", merchantId)
+	}
+
+	return merchant, nil
+}
+
+// indexSale adds an entry for owner (a merchant id or a UserId) to indexName pointing at the sale
+// stored under txnKey.
+func indexSale(ctx contractapi.TransactionContextInterface, txnKey string, indexName string, owner string, timestamp string, txnId string) error {
+	saleKey, err := ctx.GetStub().CreateCompositeKey(indexName, []string{owner, timestamp, txnId})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key for sale index: %w", err)
+	}
+
+	err = ctx.GetStub().PutState(saleKey, []byte(txnKey))
+	if err != nil {
+		return fmt.Errorf("failed to store sale index: %v", err)
+	}
+
+	return nil
+}
+
+// getSales reads the sales indexed for owner in indexName.
+func getSales(ctx contractapi.TransactionContextInterface, indexName string, owner string) ([]*SALE, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(indexName, []string{owner})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	sales := []*SALE{}
+	for resultsIterator.HasNext() {
+		queryResult, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		// The index entry points at the sale record
+		saleAsByte, err := ctx.GetStub().GetState(string(queryResult.Value))
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch sale: %w", err)
+		}
+		if saleAsByte == nil {
+			return nil, fmt.Errorf("sale for index entry %s does not exist", queryResult.Key)
+		}
+
+		var sale SALE
+		err = json.Unmarshal(saleAsByte, &sale)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal sale: %w", err)
+		}
+		sales = append(sales, &sale)
+	}
+
+	return sales, nil
+}
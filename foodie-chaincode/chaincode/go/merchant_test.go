@@ -0,0 +1,107 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func TestPayMovesBalanceAndListsTheSale(t *testing.T) {
+	ledger := newBalanceTestLedger(t)
+	ledger.createToken(TOKENDEF{ID: "MEAL", IssuerOrg: "Org1MSP", DocType: TOKEN})
+	ledger.registerAccount("admin", ADMINROLE)
+	ledger.registerAccount("canteen", MERCHANTROLE)
+	ledger.registerAccount("alice", STUDENTROLE)
+	if err := ledger.add("alice", "MEAL", "20"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ledger.add("canteen", "MEAL", "20"); err != nil {
+		t.Fatal(err)
+	}
+
+	err := ledger.runAs(newTestIdentity("admin"), func(ctx contractapi.TransactionContextInterface) error {
+		return new(SmartContract).RegisterMerchant(ctx, "cafe", "", "Main cafeteria", "food", "canteen")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payment := PAYMENT{
+		TxnID:      "p1",
+		ID:         "MEAL",
+		UserId:     "alice",
+		MerchantID: "cafe",
+		OrderRef:   "order-1",
+		Items:      []LINEITEM{{Description: "Lunch", Quantity: 2, UnitPrice: "6"}, {Description: "Coffee", Quantity: 1, UnitPrice: "3"}},
+		Total:      "15",
+	}
+	pay := func(caller string, input PAYMENT) error {
+		return ledger.runAs(newTestIdentity(caller), func(ctx contractapi.TransactionContextInterface) error {
+			_, err := new(SmartContract).Pay(ctx, input)
+			return err
+		})
+	}
+
+	// Only a Student can pay, from its own account
+	refused := payment
+	refused.UserId = "canteen"
+	assertErrorCode(t, pay("canteen", refused), ERRUNAUTHORIZED)
+	assertErrorCode(t, pay("admin", payment), ERRUNAUTHORIZED)
+
+	// Pay accepts tokens that are not transferable
+	if err := pay("alice", payment); err != nil {
+		t.Fatal(err)
+	}
+	if balance := ledger.balance("alice", "MEAL"); balance != "5" {
+		t.Fatalf("expected alice to keep 5, got %s", balance)
+	}
+	if balance := ledger.balance("canteen", "MEAL"); balance != "35" {
+		t.Fatalf("expected the settlement account to hold 35, got %s", balance)
+	}
+
+	if ledger.countKeys(SALEINDEX, "cafe") != 1 || ledger.countKeys(PURCHASEINDEX, "alice") != 1 {
+		t.Fatal("expected one sale and one purchase index entry")
+	}
+
+	listSales := func(caller string) ([]*SALE, error) {
+		var sales []*SALE
+		err := ledger.runAs(newTestIdentity(caller), func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			sales, err = new(SmartContract).ListSales(ctx, "cafe")
+			return err
+		})
+		return sales, err
+	}
+	listPurchases := func(caller string) ([]*SALE, error) {
+		var sales []*SALE
+		err := ledger.runAs(newTestIdentity(caller), func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			sales, err = new(SmartContract).ListPurchases(ctx, "alice")
+			return err
+		})
+		return sales, err
+	}
+
+	_, err = listSales("alice")
+	assertErrorCode(t, err, ERRUNAUTHORIZED)
+	_, err = listPurchases("canteen")
+	assertErrorCode(t, err, ERRUNAUTHORIZED)
+
+	sales, err := listSales("canteen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	purchases, err := listPurchases("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, listed := range [][]*SALE{sales, purchases} {
+		if len(listed) != 1 {
+			t.Fatalf("expected one sale, got %d", len(listed))
+		}
+		sale := listed[0]
+		if sale.TxnID != "p1" || sale.DocType != PAYTXN || sale.UserId != "alice" || sale.Receiver != "canteen" || sale.OrderRef != "order-1" || sale.Total != "15" || len(sale.Items) != 2 {
+			t.Fatalf("unexpected sale: %+v", sale)
+		}
+	}
+}
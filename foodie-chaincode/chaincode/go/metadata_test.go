@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+var updateMetadata = flag.Bool("update-metadata", false, "rewrite contract-metadata/metadata.json from the code")
+
+var metadataPath = filepath.Join("contract-metadata", "metadata.json")
+
+// generatedMetadata returns the metadata contractapi generates for SmartContract, as served by
+// org.hyperledger.fabric:GetMetadata.
+func generatedMetadata(t *testing.T) []byte {
+	t.Helper()
+	chaincode, err := contractapi.NewChaincode(new(SmartContract))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stub := shimtest.NewMockStub("foodie", chaincode)
+	response := stub.MockInvoke("metadata", [][]byte{[]byte("org.hyperledger.fabric:GetMetadata")})
+	if response.Status != 200 {
+		t.Fatalf("GetMetadata failed: %s", response.Message)
+	}
+
+	var indented bytes.Buffer
+	err = json.Indent(&indented, response.Payload, "", "    ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	indented.WriteString("\n")
+	return indented.Bytes()
+}
+
+// TestContractMetadataIsUpToDate checks the checked-in metadata against the code. Run
+// go test -run TestContractMetadataIsUpToDate -update-metadata to regenerate it.
+func TestContractMetadataIsUpToDate(t *testing.T) {
+	generated := generatedMetadata(t)
+
+	if *updateMetadata {
+		err := os.MkdirAll(filepath.Dir(metadataPath), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(metadataPath, generated, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checkedIn, err := os.ReadFile(metadataPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var want, got interface{}
+	if err := json.Unmarshal(checkedIn, &want); err != nil {
+		t.Fatalf("%s is not valid JSON: %v", metadataPath, err)
+	}
+	if err := json.Unmarshal(generated, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("%s is out of date, regenerate it with -update-metadata", metadataPath)
+	}
+}
+
+func TestContractMetadataPublishesTypedPayloads(t *testing.T) {
+	var chaincodeMetadata struct {
+		Contracts map[string]struct {
+			Transactions []struct {
+				Name       string `json:"name"`
+				Parameters []struct {
+					Schema map[string]interface{} `json:"schema"`
+				} `json:"parameters"`
+			} `json:"transactions"`
+		} `json:"contracts"`
+	}
+	err := json.Unmarshal(generatedMetadata(t), &chaincodeMetadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refs := make(map[string]interface{})
+	for _, transaction := range chaincodeMetadata.Contracts["SmartContract"].Transactions {
+		if len(transaction.Parameters) == 1 {
+			refs[transaction.Name] = transaction.Parameters[0].Schema["$ref"]
+		}
+	}
+
+	for name, ref := range map[string]string{
+		"MintTyped":     "#/components/schemas/FOODIE",
+		"TransferTyped": "#/components/schemas/TRANSFER",
+		"BurnTyped":     "#/components/schemas/BURNTOKEN",
+	} {
+		if refs[name] != ref {
+			t.Fatalf("expected %s to take %s, got %v", name, ref, refs[name])
+		}
+	}
+}
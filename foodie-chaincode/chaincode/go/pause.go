@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// PAUSESTRUCT records the pause state of the token contract (empty Id) or of a single token. The
+// record is kept after Unpause so the last pause can still be inspected.
+type PAUSESTRUCT struct {
+	ID         string `json:"Id"`
+	Paused     bool   `json:"Paused"`
+	Reason     string `json:"Reason"`
+	PausedBy   string `json:"PausedBy"`
+	PausedAt   string `json:"PausedAt"`
+	UnpausedBy string `json:"UnpausedBy"`
+	UnpausedAt string `json:"UnpausedAt"`
+	DocType    string `json:"DocType"`
+}
+
+const PAUSE = "PAUSE"
+const PAUSEINDEX = DOCTYPE + "~Pause"
+const PAUSEEVENT = "Pause"
+const UNPAUSEEVENT = "Unpause"
+
+// Pause stops Mint, Transfer and Burn, including their batch and allowance variants, for token id,
+// or for every token when id is empty. Reads keep working. Only an Admin can pause, and a reason
+// must be given.
+func (s *SmartContract) Pause(ctx contractapi.TransactionContextInterface, id string, reason string) error {
+	admin, err := assertCallerRole(ctx, ADMINROLE)
+	if err != nil {
+		return err
+	}
+
+	if reason == "" {
+		return newError(ERRINVALIDINPUT, "pause reason must not be empty")
+	}
+	if id != "" {
+		_, err = getRegisteredToken(ctx, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	pause, err := getPause(ctx, id)
+	if err != nil {
+		return err
+	}
+	if pause.Paused {
+		return newError(ERRDUPLICATE, "%s is already paused", pauseScope(id)).withDetail("id", id)
+	}
+
+	pause.Paused = true
+	pause.Reason = reason
+	pause.PausedBy = admin
+	pause.PausedAt, err = getRecordTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	pause.UnpausedBy = ""
+	pause.UnpausedAt = ""
+
+	return putPause(ctx, pause, PAUSEEVENT)
+}
+
+// Unpause lifts a pause set by Pause for token id, or the global pause when id is empty. A token
+// stays paused while the global pause is set. Only an Admin can unpause.
+func (s *SmartContract) Unpause(ctx contractapi.TransactionContextInterface, id string) error {
+	admin, err := assertCallerRole(ctx, ADMINROLE)
+	if err != nil {
+		return err
+	}
+
+	pause, err := getPause(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !pause.Paused {
+		return newError(ERRNOTFOUND, "%s is not paused", pauseScope(id)).withDetail("id", id)
+	}
+
+	pause.Paused = false
+	pause.UnpausedBy = admin
+	pause.UnpausedAt, err = getRecordTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	return putPause(ctx, pause, UNPAUSEEVENT)
+}
+
+// GetPauseStatus returns the pause record of token id, or of the whole contract when id is empty.
+func (s *SmartContract) GetPauseStatus(ctx contractapi.TransactionContextInterface, id string) (*PAUSESTRUCT, error) {
+	return getPause(ctx, id)
+}
+
+func pauseScope(id string) string {
+	if id == "" {
+		return "the token contract"
+	}
+	return "token " + id
+}
+
+func pauseKey(ctx contractapi.TransactionContextInterface, id string) (string, error) {
+	attributes := []string{}
+	if id != "" {
+		attributes = append(attributes, id)
+	}
+
+	pauseKey, err := ctx.GetStub().CreateCompositeKey(PAUSEINDEX, attributes)
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key for pause: %w", err)
+	}
+
+	return pauseKey, nil
+}
+
+// getPause reads the pause record of id, returning an unpaused record if there is none.
+func getPause(ctx contractapi.TransactionContextInterface, id string) (*PAUSESTRUCT, error) {
+	pauseKey, err := pauseKey(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	pauseAsByte, err := ctx.GetStub().GetState(pauseKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch pause: %w", err)
+	}
+
+	pause := PAUSESTRUCT{ID: id, DocType: PAUSE}
+	if pauseAsByte != nil {
+		err = json.Unmarshal(pauseAsByte, &pause)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal pause: %w", err)
+		}
+	}
+
+	return &pause, nil
+}
+
+// putPause stores pause and emits it as eventType.
+func putPause(ctx contractapi.TransactionContextInterface, pause *PAUSESTRUCT, eventType string) error {
+	pauseKey, err := pauseKey(ctx, pause.ID)
+	if err != nil {
+		return err
+	}
+
+	pauseAsByte, err := json.Marshal(pause)
+	if err != nil {
+		return fmt.Errorf("failed to marshal pause: %w", err)
+	}
+
+	err = ctx.GetStub().PutState(pauseKey, pauseAsByte)
+	if err != nil {
+		return fmt.Errorf("failed to store pause state: %v", err)
+	}
+
+	// Emit the change in the same envelope as balance events
+	event, err := newEvent(ctx, eventType, "", []EVENTLEG{})
+	if err != nil {
+		return err
+	}
+	event.ID = pause.ID
+	event.Pause = pause
+
+	return setEvent(ctx, event)
+}
+
+// assertNotPaused fails with PAUSED when the token contract or token id is paused.
+func assertNotPaused(ctx contractapi.TransactionContextInterface, id string) error {
+	for _, scope := range []string{"", id} {
+		pause, err := getPause(ctx, scope)
+		if err != nil {
+			return err
+		}
+		if pause.Paused {
+			return newError(ERRPAUSED, "%s is paused: %s", pauseScope(scope), pause.Reason).
+				withDetail("id", scope).
+				withDetail("pausedBy", pause.PausedBy).
+				withDetail("pausedAt", pause.PausedAt)
+		}
+	}
+
+	return nil
+}
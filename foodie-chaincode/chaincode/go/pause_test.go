@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func (l *balanceTestLedger) setPaused(id string, paused bool) {
+	l.t.Helper()
+	err := l.run(func(ctx contractapi.TransactionContextInterface) error {
+		return putPause(ctx, &PAUSESTRUCT{ID: id, Paused: paused, Reason: "test", DocType: PAUSE}, PAUSEEVENT)
+	})
+	if err != nil {
+		l.t.Fatal(err)
+	}
+}
+
+func (l *balanceTestLedger) assertPaused(id string, paused bool) {
+	l.t.Helper()
+	err := l.run(func(ctx contractapi.TransactionContextInterface) error {
+		return assertNotPaused(ctx, id)
+	})
+	if !paused {
+		if err != nil {
+			l.t.Fatalf("expected %s not to be paused, got %v", id, err)
+		}
+		return
+	}
+	if err == nil {
+		l.t.Fatalf("expected %s to be paused", id)
+	}
+	if decoded := decodeChaincodeError(l.t, err); decoded.Code != ERRPAUSED {
+		l.t.Fatalf("expected code %s, got %s", ERRPAUSED, decoded.Code)
+	}
+}
+
+func TestPauseScopes(t *testing.T) {
+	ledger := newBalanceTestLedger(t)
+	ledger.assertPaused("MEAL", false)
+
+	ledger.setPaused("MEAL", true)
+	ledger.assertPaused("MEAL", true)
+	ledger.assertPaused("SNACK", false)
+
+	ledger.setPaused("", true)
+	ledger.assertPaused("SNACK", true)
+
+	ledger.setPaused("MEAL", false)
+	ledger.assertPaused("MEAL", true)
+
+	ledger.setPaused("", false)
+	ledger.assertPaused("MEAL", false)
+	ledger.assertPaused("SNACK", false)
+}
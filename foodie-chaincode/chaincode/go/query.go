@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// LEDGERRECORD holds any MINTTX, TRANSFERTXN, REASSIGNTXN, PAYTXN, REFUNDTXN, BURNTXN or OWNER
+// document returned by a query. Fields that do not apply to the record's DocType are left empty.
+// Refunded and Refunds are set on transfers and payments that were refunded.
+type LEDGERRECORD struct {
+	DocType         string     `json:"DocType"`
+	TxnID           string     `json:"TxnId,omitempty" metadata:",optional"`
+	ID              string     `json:"Id,omitempty" metadata:",optional"`
+	UserID          string     `json:"UserId,omitempty" metadata:",optional"`
+	Receiver        string     `json:"Receiver,omitempty" metadata:",optional"`
+	Spender         string     `json:"Spender,omitempty" metadata:",optional"`
+	BatchID         string     `json:"BatchId,omitempty" metadata:",optional"`
+	Inputs          []string   `json:"Inputs,omitempty" metadata:",optional"`
+	Amount          AMOUNT     `json:"Amount,omitempty" metadata:",optional"`
+	BurnTokenID     string     `json:"BurnTokenId,omitempty" metadata:",optional"`
+	BurnTokenAmount AMOUNT     `json:"BurnTokenAmount,omitempty" metadata:",optional"`
+	Reason          string     `json:"Reason,omitempty" metadata:",optional"`
+	MerchantID      string     `json:"MerchantId,omitempty" metadata:",optional"`
+	OrderRef        string     `json:"OrderRef,omitempty" metadata:",optional"`
+	Items           []LINEITEM `json:"Items,omitempty" metadata:",optional"`
+	Total           AMOUNT     `json:"Total,omitempty" metadata:",optional"`
+	OriginalTxnID   string     `json:"OriginalTxnId,omitempty" metadata:",optional"`
+	RefundedBy      string     `json:"RefundedBy,omitempty" metadata:",optional"`
+	Refunded        AMOUNT     `json:"Refunded,omitempty" metadata:",optional"`
+	Refunds         []string   `json:"Refunds,omitempty" metadata:",optional"`
+	Timestamp       string     `json:"Timestamp,omitempty" metadata:",optional"`
+}
+
+// QUERYRESULT is one page of query results. Pass Bookmark back to fetch the next page.
+type QUERYRESULT struct {
+	Records      []*LEDGERRECORD `json:"Records"`
+	Bookmark     string          `json:"Bookmark"`
+	FetchedCount int             `json:"FetchedCount"`
+}
+
+const MAXPAGESIZE = 200
+
+// RECORDTIMEFORMAT keeps record timestamps fixed width so they sort and compare as strings.
+const RECORDTIMEFORMAT = time.RFC3339
+
+var txnDocTypes = []string{MINTTXN, TRANSFERTXN, REASSIGNTXN, PAYTXN, REFUNDTXN, BURN}
+
+// QueryByDocType returns a page of documents of the given DocType.
+func (s *SmartContract) QueryByDocType(ctx contractapi.TransactionContextInterface, docType string, pageSize int, bookmark string) (*QUERYRESULT, error) {
+	// Only an Admin or an Auditor can query the ledger
+	_, err := assertCallerRole(ctx, ADMINROLE, AUDITORROLE)
+	if err != nil {
+		return nil, err
+	}
+
+	selector := map[string]interface{}{
+		"DocType": docType,
+	}
+
+	return getPaginatedQueryResult(ctx, selector, pageSize, bookmark)
+}
+
+// QueryByUser returns a page of transactions sent, received, paid, refunded or burnt by user.
+func (s *SmartContract) QueryByUser(ctx contractapi.TransactionContextInterface, user string, pageSize int, bookmark string) (*QUERYRESULT, error) {
+	// Only the account owner, an Admin or an Auditor can query a user's transactions
+	err := assertAccountOwner(ctx, user)
+	if err != nil {
+		_, err = assertCallerRole(ctx, ADMINROLE, AUDITORROLE)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	selector := map[string]interface{}{
+		"DocType": map[string]interface{}{"$in": txnDocTypes},
+		"$or": []map[string]interface{}{
+			{"UserId": user},
+			{"Receiver": user},
+			{"BurnTokenId": user},
+		},
+	}
+
+	return getPaginatedQueryResult(ctx, selector, pageSize, bookmark)
+}
+
+// QueryByToken returns a page of transactions for token id.
+func (s *SmartContract) QueryByToken(ctx contractapi.TransactionContextInterface, id string, pageSize int, bookmark string) (*QUERYRESULT, error) {
+	// Only an Admin or an Auditor can query the ledger
+	_, err := assertCallerRole(ctx, ADMINROLE, AUDITORROLE)
+	if err != nil {
+		return nil, err
+	}
+
+	selector := map[string]interface{}{
+		"DocType": map[string]interface{}{"$in": txnDocTypes},
+		"Id":      id,
+	}
+
+	return getPaginatedQueryResult(ctx, selector, pageSize, bookmark)
+}
+
+// QueryByDateRange returns a page of transactions recorded between from and to (inclusive, RFC3339).
+func (s *SmartContract) QueryByDateRange(ctx contractapi.TransactionContextInterface, from string, to string, pageSize int, bookmark string) (*QUERYRESULT, error) {
+	// Only an Admin or an Auditor can query the ledger
+	_, err := assertCallerRole(ctx, ADMINROLE, AUDITORROLE)
+	if err != nil {
+		return nil, err
+	}
+
+	fromTime, err := parseRecordTime(from)
+	if err != nil {
+		return nil, err
+	}
+	toTime, err := parseRecordTime(to)
+	if err != nil {
+		return nil, err
+	}
+	if fromTime > toTime {
+		return nil, newError(ERRINVALIDINPUT, "from must not be after to")
+	}
+
+	selector := map[string]interface{}{
+		"DocType":   map[string]interface{}{"$in": txnDocTypes},
+		"Timestamp": map[string]interface{}{"$gte": fromTime, "$lte": toTime},
+	}
+
+	return getPaginatedQueryResult(ctx, selector, pageSize, bookmark)
+}
+
+// getPaginatedQueryResult runs a CouchDB selector built from Go values, so user input is always
+// JSON encoded rather than spliced into the query string.
+func getPaginatedQueryResult(ctx contractapi.TransactionContextInterface, selector map[string]interface{}, pageSize int, bookmark string) (*QUERYRESULT, error) {
+	if pageSize <= 0 || pageSize > MAXPAGESIZE {
+		return nil, newError(ERRINVALIDINPUT, "page size must be between 1 and %d", MAXPAGESIZE)
+	}
+
+	queryAsByte, err := json.Marshal(map[string]interface{}{"selector": selector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal query: %w", err)
+	}
+
+	resultsIterator, responseMetadata, err := ctx.GetStub().GetQueryResultWithPagination(string(queryAsByte), int32(pageSize), bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	result := &QUERYRESULT{Records: []*LEDGERRECORD{}}
+	for resultsIterator.HasNext() {
+		queryResult, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var record LEDGERRECORD
+		err = json.Unmarshal(queryResult.Value, &record)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal record %s: %w", queryResult.Key, err)
+		}
+		result.Records = append(result.Records, &record)
+	}
+
+	result.Bookmark = responseMetadata.Bookmark
+	result.FetchedCount = int(responseMetadata.FetchedRecordsCount)
+
+	return result, nil
+}
+
+// getRecordTimestamp returns the transaction timestamp formatted for storing on ledger records.
+func getRecordTimestamp(ctx contractapi.TransactionContextInterface) (string, error) {
+	timestamp, err := getTxTimestamp(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return timestamp.Format(RECORDTIMEFORMAT), nil
+}
+
+// parseRecordTime validates an RFC3339 time and normalises it to the stored record format.
+func parseRecordTime(value string) (string, error) {
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return "", newError(ERRINVALIDINPUT, "invalid time %q, expected RFC3339", value)
+	}
+
+	return parsed.UTC().Format(RECORDTIMEFORMAT), nil
+}
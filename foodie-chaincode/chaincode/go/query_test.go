@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// queryStub answers rich queries, which the mock stub does not run, with records and remembers
+// the last query.
+type queryStub struct {
+	*shimtest.MockStub
+	records []*queryresult.KV
+	query   string
+}
+
+func (s *queryStub) GetQueryResultWithPagination(query string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	s.query = query
+	return &queryIterator{records: s.records}, &pb.QueryResponseMetadata{FetchedRecordsCount: int32(len(s.records)), Bookmark: "next"}, nil
+}
+
+type queryIterator struct {
+	records []*queryresult.KV
+}
+
+func (i *queryIterator) HasNext() bool { return len(i.records) > 0 }
+func (i *queryIterator) Close() error  { return nil }
+func (i *queryIterator) Next() (*queryresult.KV, error) {
+	record := i.records[0]
+	i.records = i.records[1:]
+	return record, nil
+}
+
+func TestQueriesCheckAccessPageSizeAndDates(t *testing.T) {
+	ledger := newBalanceTestLedger(t)
+	ledger.registerAccount("auditor", AUDITORROLE)
+	ledger.registerAccount("alice", STUDENTROLE)
+	stub := &queryStub{MockStub: ledger.stub, records: []*queryresult.KV{
+		{Key: "t1", Value: []byte(`{"DocType": "TRANSFERTXN", "TxnId": "t1", "Id": "MEAL", "UserId": "alice", "Receiver": "bob", "Amount": "5"}`)},
+	}}
+
+	query := func(caller string, fn func(ctx contractapi.TransactionContextInterface) (*QUERYRESULT, error)) (*QUERYRESULT, error) {
+		var result *QUERYRESULT
+		err := ledger.runAs(newTestIdentity(caller), func(ctx contractapi.TransactionContextInterface) error {
+			ctx.(*contractapi.TransactionContext).SetStub(stub)
+			var err error
+			result, err = fn(ctx)
+			return err
+		})
+		return result, err
+	}
+	contract := new(SmartContract)
+
+	// Only an Admin or an Auditor can query the whole ledger
+	_, err := query("alice", func(ctx contractapi.TransactionContextInterface) (*QUERYRESULT, error) {
+		return contract.QueryByDocType(ctx, OWNER, 10, "")
+	})
+	assertErrorCode(t, err, ERRUNAUTHORIZED)
+	_, err = query("alice", func(ctx contractapi.TransactionContextInterface) (*QUERYRESULT, error) {
+		return contract.QueryByToken(ctx, "MEAL", 10, "")
+	})
+	assertErrorCode(t, err, ERRUNAUTHORIZED)
+	_, err = query("alice", func(ctx contractapi.TransactionContextInterface) (*QUERYRESULT, error) {
+		return contract.QueryByDateRange(ctx, "2024-01-01T00:00:00Z", "2024-02-01T00:00:00Z", 10, "")
+	})
+	assertErrorCode(t, err, ERRUNAUTHORIZED)
+
+	// An account owner can query its own transactions only
+	_, err = query("alice", func(ctx contractapi.TransactionContextInterface) (*QUERYRESULT, error) {
+		return contract.QueryByUser(ctx, "bob", 10, "")
+	})
+	assertErrorCode(t, err, ERRUNAUTHORIZED)
+	result, err := query("alice", func(ctx contractapi.TransactionContextInterface) (*QUERYRESULT, error) {
+		return contract.QueryByUser(ctx, "alice", 10, "")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Records) != 1 || result.Records[0].TxnID != "t1" || result.Records[0].Amount != "5" || result.Bookmark != "next" || result.FetchedCount != 1 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+
+	// Page sizes are bounded
+	for _, pageSize := range []int{0, -1, MAXPAGESIZE + 1} {
+		_, err = query("auditor", func(ctx contractapi.TransactionContextInterface) (*QUERYRESULT, error) {
+			return contract.QueryByToken(ctx, "MEAL", pageSize, "")
+		})
+		assertErrorCode(t, err, ERRINVALIDINPUT)
+	}
+	if _, err = query("auditor", func(ctx contractapi.TransactionContextInterface) (*QUERYRESULT, error) {
+		return contract.QueryByToken(ctx, "MEAL", MAXPAGESIZE, "")
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Dates must be RFC3339 and in order, and are normalised to UTC in the selector
+	for _, dates := range [][2]string{
+		{"2024-01-01", "2024-02-01T00:00:00Z"},
+		{"2024-01-01T00:00:00Z", "yesterday"},
+		{"2024-02-01T00:00:00Z", "2024-01-01T00:00:00Z"},
+	} {
+		_, err = query("auditor", func(ctx contractapi.TransactionContextInterface) (*QUERYRESULT, error) {
+			return contract.QueryByDateRange(ctx, dates[0], dates[1], 10, "")
+		})
+		assertErrorCode(t, err, ERRINVALIDINPUT)
+	}
+	_, err = query("auditor", func(ctx contractapi.TransactionContextInterface) (*QUERYRESULT, error) {
+		return contract.QueryByDateRange(ctx, "2024-01-01T05:30:00+05:30", "2024-02-01T00:00:00Z", 10, "")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var selector struct {
+		Selector struct {
+			DocType   map[string][]string `json:"DocType"`
+			Timestamp map[string]string   `json:"Timestamp"`
+		} `json:"selector"`
+	}
+	if err := json.Unmarshal([]byte(stub.query), &selector); err != nil {
+		t.Fatal(err)
+	}
+	if selector.Selector.Timestamp["$gte"] != "2024-01-01T00:00:00Z" || selector.Selector.Timestamp["$lte"] != "2024-02-01T00:00:00Z" {
+		t.Fatalf("unexpected date range in %s", stub.query)
+	}
+	if len(selector.Selector.DocType["$in"]) != len(txnDocTypes) {
+		t.Fatalf("expected every transaction doc type in %s", stub.query)
+	}
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// ACCOUNTLINK records that account OldUserId was replaced by NewUserId, and the balances and roles
+// that were moved from one to the other. Moved lists one leg per token id.
+type ACCOUNTLINK struct {
+	OldUserID string     `json:"OldUserId"`
+	NewUserID string     `json:"NewUserId"`
+	LinkedBy  string     `json:"LinkedBy"`
+	LinkedAt  string     `json:"LinkedAt"`
+	TxnID     string     `json:"TxnId"`
+	Moved     []EVENTLEG `json:"Moved"`
+	Roles     []string   `json:"Roles"`
+	DocType   string     `json:"DocType"`
+}
+
+const LINK = "LINK"
+const LINKINDEX = DOCTYPE + "~Link"
+const REASSIGNTXN = "REASSIGNTXN"
+const REASSIGNEVENT = "Reassign"
+
+// ReassignAccount moves every balance, allowance and role of account oldUser to account newUser,
+// e.g. when a student is issued a new card, and then closes oldUser so its identity is refused. Each moved balance is recorded as a
+// REASSIGNTXN under both accounts so their statements continue into each other, and the link is
+// kept under oldUser. Only an Admin can reassign an account.
+func (s *SmartContract) ReassignAccount(ctx contractapi.TransactionContextInterface, oldUser string, newUser string) (*ACCOUNTLINK, error) {
+	admin, err := assertCallerRole(ctx, ADMINROLE)
+	if err != nil {
+		return nil, err
+	}
+
+	if oldUser == newUser {
+		return nil, newError(ERRINVALIDINPUT, "an account cannot be reassigned to itself")
+	}
+	oldAccount, err := getAccount(ctx, oldUser)
+	if err != nil {
+		return nil, err
+	}
+	if oldAccount == nil {
+		return nil, newError(ERRNOTFOUND, "account %s does not exist", oldUser)
+	}
+	if oldAccount.Closed {
+		return nil, newError(ERRDUPLICATE, "account %s was already reassigned to %s", oldUser, oldAccount.SuccessorUserID).
+			withDetail("userId", oldUser).
+			withDetail("successorUserId", oldAccount.SuccessorUserID)
+	}
+	newAccount, err := getAccount(ctx, newUser)
+	if err != nil {
+		return nil, err
+	}
+	if newAccount == nil {
+		return nil, newError(ERRNOTFOUND, "account %s does not exist", newUser)
+	}
+	if newAccount.Closed {
+		return nil, newError(ERRINVALIDINPUT, "account %s is closed", newUser).withDetail("userId", newUser)
+	}
+
+	// The Fabric transaction id identifies reassignment records
+	var link ACCOUNTLINK
+	link.OldUserID = oldUser
+	link.NewUserID = newUser
+	link.LinkedBy = admin
+	link.TxnID = ctx.GetStub().GetTxID()
+	link.Moved = []EVENTLEG{}
+	link.Roles = []string{}
+	link.DocType = LINK
+	link.LinkedAt, err = getRecordTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ids, err := getAccountTokenIDs(ctx, oldUser)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		balance, err := getOwnerBalance(ctx, oldUser, id)
+		if err != nil {
+			return nil, err
+		}
+		positive, err := isPositiveAmount(balance)
+		if err != nil {
+			return nil, err
+		}
+
+		if positive {
+			err = assertNotPaused(ctx, id)
+			if err != nil {
+				return nil, err
+			}
+			err = reassignBalance(ctx, &link, id, balance)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		err = reassignAllowances(ctx, oldUser, newUser, id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = reassignRoles(ctx, &link)
+	if err != nil {
+		return nil, err
+	}
+
+	// Close the old account under both the UserId and the identity
+	oldAccount.Closed = true
+	oldAccount.SuccessorUserID = newUser
+	err = putAccount(ctx, oldAccount)
+	if err != nil {
+		return nil, err
+	}
+
+	linkKey, err := ctx.GetStub().CreateCompositeKey(LINKINDEX, []string{oldUser})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key for account link: %w", err)
+	}
+
+	linkAsByte, err := json.Marshal(link)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal account link: %w", err)
+	}
+
+	err = ctx.GetStub().PutState(linkKey, linkAsByte)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store account link state: %v", err)
+	}
+
+	err = emitEvent(ctx, REASSIGNEVENT, link.TxnID, link.Moved)
+	if err != nil {
+		return nil, err
+	}
+
+	return &link, nil
+}
+
+// GetAccountLink returns the reassignment of account oldUser. Only the new account owner, an Admin or
+// an Auditor can read it, as the identity of the closed account is refused.
+func (s *SmartContract) GetAccountLink(ctx contractapi.TransactionContextInterface, oldUser string) (*ACCOUNTLINK, error) {
+	linkKey, err := ctx.GetStub().CreateCompositeKey(LINKINDEX, []string{oldUser})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key for account link: %w", err)
+	}
+
+	linkAsByte, err := ctx.GetStub().GetState(linkKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch account link: %w", err)
+	}
+	if linkAsByte == nil {
+		return nil, newError(ERRNOTFOUND, "account %s was not reassigned", oldUser).withDetail("userId", oldUser)
+	}
+
+	var link ACCOUNTLINK
+	err = json.Unmarshal(linkAsByte, &link)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal account link: %w", err)
+	}
+
+	err = assertAccountOwner(ctx, link.NewUserID)
+	if err != nil {
+		_, err = assertCallerRole(ctx, ADMINROLE, AUDITORROLE)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &link, nil
+}
+
+// reassignRoles moves the roles of link.OldUserID to link.NewUserID, keeping who granted them, and
+// lists them in link.Roles.
+func reassignRoles(ctx contractapi.TransactionContextInterface, link *ACCOUNTLINK) error {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(DOCTYPE+"~Role", []string{link.OldUserID})
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResult, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+
+		var role ROLESTRUCT
+		err = json.Unmarshal(queryResult.Value, &role)
+		if err != nil {
+			return fmt.Errorf("failed to unmarshal role: %w", err)
+		}
+
+		err = putRole(ctx, link.NewUserID, role.Role, role.GrantedBy)
+		if err != nil {
+			return err
+		}
+
+		err = ctx.GetStub().DelState(queryResult.Key)
+		if err != nil {
+			return fmt.Errorf("failed to delete role state: %v", err)
+		}
+		link.Roles = append(link.Roles, role.Role)
+	}
+
+	return nil
+}
+
+// getAccountTokenIDs returns the sorted ids of every registered token and of every unregistered
+// token userId holds an owner entry for.
+func getAccountTokenIDs(ctx contractapi.TransactionContextInterface, userId string) ([]string, error) {
+	ids := map[string]bool{}
+
+	tokenIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(DOCTYPE+"~Token", []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer tokenIterator.Close()
+
+	for tokenIterator.HasNext() {
+		queryResult, err := tokenIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var token TOKENDEF
+		err = json.Unmarshal(queryResult.Value, &token)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal token %s: %w", queryResult.Key, err)
+		}
+		ids[token.ID] = true
+	}
+
+	ownerIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(DOCTYPE+"~Owner", []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer ownerIterator.Close()
+
+	for ownerIterator.HasNext() {
+		queryResult, err := ownerIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var owner OWNERSTRUCT
+		err = json.Unmarshal(queryResult.Value, &owner)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal owner entry %s: %w", queryResult.Key, err)
+		}
+		if owner.UserID == userId {
+			ids[owner.ID] = true
+		}
+	}
+
+	sortedIDs := make([]string, 0, len(ids))
+	for id := range ids {
+		sortedIDs = append(sortedIDs, id)
+	}
+	sort.Strings(sortedIDs)
+
+	return sortedIDs, nil
+}
+
+// reassignBalance moves amount of token id from link.OldUserId to link.NewUserId, records the move
+// as a REASSIGNTXN and appends it to link.Moved.
+func reassignBalance(ctx contractapi.TransactionContextInterface, link *ACCOUNTLINK, id string, amount AMOUNT) error {
+	err := debitBalance(ctx, link.OldUserID, id, amount)
+	if err != nil {
+		return err
+	}
+
+	err = addBalance(ctx, link.NewUserID, id, amount)
+	if err != nil {
+		return err
+	}
+
+	// Lots move as they are, including lots that already expired
+	lots, err := spendLots(ctx, link.OldUserID, id, amount, true)
+	if err != nil {
+		return err
+	}
+	err = creditLots(ctx, link.NewUserID, lots)
+	if err != nil {
+		return err
+	}
+
+	var txn TRANSFER
+	txn.DocType = REASSIGNTXN
+	txn.ID = id
+	txn.Amount = amount
+	txn.TxnID = link.TxnID
+	txn.UserId = link.OldUserID
+	txn.Receiver = link.NewUserID
+	txn.Timestamp = link.LinkedAt
+
+	indexName := "TxnID~" + DOCTYPE
+	TxnCompositeKey, err := ctx.GetStub().CreateCompositeKey(indexName, []string{txn.TxnID, txn.ID})
+	if err != nil {
+		return err
+	}
+
+	TXNAsByte, err := json.Marshal(txn)
+	if err != nil {
+		return fmt.Errorf("failed to marshal reassignment: %w", err)
+	}
+
+	err = ctx.GetStub().PutState(TxnCompositeKey, TXNAsByte)
+	if err != nil {
+		return fmt.Errorf("failed to store composite key state: %v", err)
+	}
+
+	err = indexTxn(ctx, TxnCompositeKey, txn.ID, txn.UserId, txn.Receiver, txn.Timestamp, txn.TxnID)
+	if err != nil {
+		return err
+	}
+
+	link.Moved = append(link.Moved, EVENTLEG{ID: id, From: link.OldUserID, To: link.NewUserID, Amount: amount})
+	return nil
+}
+
+// reassignAllowances hands the allowances oldUser granted or received for token id over to
+// newUser, adding to any allowance newUser already has with the same account. Allowances between
+// oldUser and newUser are dropped. The merged allowances are computed from a single read of the
+// allowances of id before anything is written, since a transaction does not read its own writes.
+func reassignAllowances(ctx contractapi.TransactionContextInterface, oldUser string, newUser string, id string) error {
+	type allowancePair struct {
+		owner, spender string
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(DOCTYPE+"~Allowance", []string{id})
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+
+	allowances := make(map[allowancePair]AMOUNT)
+	var reassigned []ALLOWANCESTRUCT
+	for resultsIterator.HasNext() {
+		queryResult, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+
+		var allowance ALLOWANCESTRUCT
+		err = json.Unmarshal(queryResult.Value, &allowance)
+		if err != nil {
+			return fmt.Errorf("failed to unmarshal allowance %s: %w", queryResult.Key, err)
+		}
+		allowances[allowancePair{allowance.Owner, allowance.Spender}] = allowance.Amount
+		if allowance.Owner == oldUser || allowance.Spender == oldUser {
+			reassigned = append(reassigned, allowance)
+		}
+	}
+
+	// Every allowance of oldUser is removed and its amount added to the matching allowance of newUser
+	changed := make(map[allowancePair]bool)
+	for _, allowance := range reassigned {
+		pair := allowancePair{allowance.Owner, allowance.Spender}
+		allowances[pair] = "0"
+		changed[pair] = true
+
+		if pair.owner == oldUser {
+			pair.owner = newUser
+		}
+		if pair.spender == oldUser {
+			pair.spender = newUser
+		}
+		if pair.owner == pair.spender {
+			continue
+		}
+
+		allowances[pair], err = addAmounts(allowances[pair], allowance.Amount)
+		if err != nil {
+			return err
+		}
+		changed[pair] = true
+	}
+
+	// Write in a fixed order so every endorser produces the same write set
+	pairs := make([]allowancePair, 0, len(changed))
+	for pair := range changed {
+		pairs = append(pairs, pair)
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].owner != pairs[j].owner {
+			return pairs[i].owner < pairs[j].owner
+		}
+		return pairs[i].spender < pairs[j].spender
+	})
+
+	for _, pair := range pairs {
+		err = putAllowance(ctx, pair.owner, pair.spender, id, allowances[pair])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
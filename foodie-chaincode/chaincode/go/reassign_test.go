@@ -0,0 +1,185 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func TestReassignAllowancesMergesAndDropsSelfAllowances(t *testing.T) {
+	ledger := newBalanceTestLedger(t)
+	err := ledger.run(func(ctx contractapi.TransactionContextInterface) error {
+		for _, allowance := range []ALLOWANCESTRUCT{
+			{Owner: "alice", Spender: "canteen", Amount: "30"},
+			{Owner: "canteen", Spender: "alice", Amount: "4"},
+			{Owner: "bob", Spender: "canteen", Amount: "5"},
+			{Owner: "alice", Spender: "bob", Amount: "9"},
+		} {
+			err := putAllowance(ctx, allowance.Owner, allowance.Spender, "MEAL", allowance.Amount)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ledger.run(func(ctx contractapi.TransactionContextInterface) error {
+		return reassignAllowances(ctx, "alice", "bob", "MEAL")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ledger.run(func(ctx contractapi.TransactionContextInterface) error {
+		for _, expected := range []struct {
+			owner, spender string
+			amount         AMOUNT
+		}{
+			{"bob", "canteen", "35"},
+			{"canteen", "bob", "4"},
+			{"alice", "canteen", "0"},
+			{"canteen", "alice", "0"},
+			{"alice", "bob", "0"},
+			{"bob", "bob", "0"},
+		} {
+			amount, err := getAllowance(ctx, expected.owner, expected.spender, "MEAL")
+			if err != nil {
+				return err
+			}
+			if amount != expected.amount {
+				t.Errorf("expected allowance %s -> %s to be %s, got %s", expected.owner, expected.spender, expected.amount, amount)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReassignAccountMovesBalancesAndClosesTheOldAccount(t *testing.T) {
+	ledger := newBalanceTestLedger(t)
+	ledger.createToken(TOKENDEF{ID: "MEAL", IssuerOrg: "Org1MSP", Transferable: true, DocType: TOKEN})
+	ledger.createToken(TOKENDEF{ID: "HOT", IssuerOrg: "Org1MSP", Transferable: true, DeltaWrites: true, DocType: TOKEN})
+	ledger.createToken(TOKENDEF{ID: "COIN", IssuerOrg: "Org1MSP", Transferable: true, UTXO: true, DocType: TOKEN})
+	ledger.registerAccount("admin", ADMINROLE)
+	ledger.registerAccount("alice", STUDENTROLE, BURNERROLE)
+	ledger.registerAccount("alice2", STUDENTROLE)
+	for _, balance := range []struct {
+		id     string
+		amount AMOUNT
+	}{{"MEAL", "10"}, {"HOT", "7"}, {"COIN", "3"}, {"COIN", "4"}} {
+		if err := ledger.add("alice", balance.id, balance.amount); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ledger.add("alice2", "MEAL", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	reassign := func(identity *testIdentity) (*ACCOUNTLINK, error) {
+		var link *ACCOUNTLINK
+		err := ledger.runAs(identity, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			link, err = new(SmartContract).ReassignAccount(ctx, "alice", "alice2")
+			return err
+		})
+		return link, err
+	}
+
+	_, err := reassign(newTestIdentity("alice"))
+	assertErrorCode(t, err, ERRUNAUTHORIZED)
+
+	link, err := reassign(newTestIdentity("admin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(link.Moved) != 3 {
+		t.Fatalf("expected 3 moved balances, got %v", link.Moved)
+	}
+	if len(link.Roles) != 2 {
+		t.Fatalf("expected 2 moved roles, got %v", link.Roles)
+	}
+
+	for _, expected := range []struct {
+		userId, id string
+		amount     AMOUNT
+	}{
+		{"alice", "MEAL", "0"},
+		{"alice", "HOT", "0"},
+		{"alice", "COIN", "0"},
+		{"alice2", "MEAL", "11"},
+		{"alice2", "HOT", "7"},
+		{"alice2", "COIN", "7"},
+	} {
+		if balance := ledger.balance(expected.userId, expected.id); balance != expected.amount {
+			t.Errorf("expected %s balance of %s to be %s, got %s", expected.id, expected.userId, expected.amount, balance)
+		}
+	}
+
+	// The old account is closed and cannot be credited or reassigned again
+	err = ledger.run(func(ctx contractapi.TransactionContextInterface) error {
+		account, err := getAccount(ctx, "alice")
+		if err != nil {
+			return err
+		}
+		if !account.Closed || account.SuccessorUserID != "alice2" {
+			t.Errorf("expected alice to be closed in favour of alice2, got %+v", account)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertErrorCode(t, ledger.add("alice", "MEAL", "1"), ERRFROZEN)
+
+	// The roles moved with the balances
+	err = ledger.run(func(ctx contractapi.TransactionContextInterface) error {
+		for _, expected := range []struct {
+			userId, role string
+			granted      bool
+		}{
+			{"alice", STUDENTROLE, false},
+			{"alice", BURNERROLE, false},
+			{"alice2", STUDENTROLE, true},
+			{"alice2", BURNERROLE, true},
+		} {
+			granted, err := hasRole(ctx, expected.userId, expected.role)
+			if err != nil {
+				return err
+			}
+			if granted != expected.granted {
+				t.Errorf("expected %s to have role %s: %v", expected.userId, expected.role, expected.granted)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The identity of the closed account is refused
+	err = ledger.runAs(newTestIdentity("alice"), func(ctx contractapi.TransactionContextInterface) error {
+		_, err := getCallerUserID(ctx)
+		return err
+	})
+	assertErrorCode(t, err, ERRUNAUTHORIZED)
+	err = ledger.runAs(newTestIdentity("alice"), func(ctx contractapi.TransactionContextInterface) error {
+		_, err := new(SmartContract).GetAccountLink(ctx, "alice")
+		return err
+	})
+	assertErrorCode(t, err, ERRUNAUTHORIZED)
+	err = ledger.runAs(newTestIdentity("alice2"), func(ctx contractapi.TransactionContextInterface) error {
+		_, err := new(SmartContract).GetAccountLink(ctx, "alice")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = reassign(newTestIdentity("admin"))
+	assertErrorCode(t, err, ERRDUPLICATE)
+}
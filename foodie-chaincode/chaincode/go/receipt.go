@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// RECEIPT records the outcome of a request under its client supplied TxnId. RequestHash
+// identifies the request so an identical retry gets this receipt back instead of an error, and
+// Result lists the balance movements the request applied.
+type RECEIPT struct {
+	TxnID       string     `json:"TxnId"`
+	Function    string     `json:"Function"`
+	RequestHash string     `json:"RequestHash"`
+	FabricTxID  string     `json:"FabricTxId"`
+	SubmittedBy string     `json:"SubmittedBy"`
+	Timestamp   string     `json:"Timestamp"`
+	Result      []EVENTLEG `json:"Result"`
+	DocType     string     `json:"DocType"`
+}
+
+const RECEIPTDOC = "RECEIPT"
+const RECEIPTINDEX = DOCTYPE + "~Receipt"
+
+// GetReceipt returns the receipt stored for txnId, so a client that lost the response of a
+// submitted request can learn whether it was committed. Only the submitter, the accounts it moved
+// tokens between, an Admin or an Auditor can read a receipt.
+func (s *SmartContract) GetReceipt(ctx contractapi.TransactionContextInterface, txnId string) (*RECEIPT, error) {
+	receipt, err := getReceipt(ctx, txnId)
+	if err != nil {
+		return nil, err
+	}
+	if receipt == nil {
+		return nil, newError(ERRNOTFOUND, "no receipt for transaction %s", txnId).withDetail("txnId", txnId)
+	}
+
+	callerUserID, err := getCallerUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if receipt.SubmittedBy == callerUserID {
+		return receipt, nil
+	}
+	for _, leg := range receipt.Result {
+		if leg.From == callerUserID || leg.To == callerUserID {
+			return receipt, nil
+		}
+	}
+
+	_, err = assertCallerRole(ctx, ADMINROLE, AUDITORROLE)
+	if err != nil {
+		return nil, err
+	}
+
+	return receipt, nil
+}
+
+// getReceipt reads the receipt of txnId, returning nil if there is none.
+func getReceipt(ctx contractapi.TransactionContextInterface, txnId string) (*RECEIPT, error) {
+	receiptKey, err := ctx.GetStub().CreateCompositeKey(RECEIPTINDEX, []string{txnId})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key for receipt: %w", err)
+	}
+
+	receiptAsByte, err := ctx.GetStub().GetState(receiptKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch receipt: %w", err)
+	}
+	if receiptAsByte == nil {
+		return nil, nil
+	}
+
+	var receipt RECEIPT
+	err = json.Unmarshal(receiptAsByte, &receipt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal receipt: %w", err)
+	}
+
+	return &receipt, nil
+}
+
+// hashRequest identifies a request by the function it calls and its decoded payload, so the typed
+// and legacy variants of a transaction hash the same request alike.
+func hashRequest(function string, input interface{}) (string, error) {
+	inputAsByte, err := json.Marshal(input)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	hash := sha256.Sum256(append([]byte(function+"\n"), inputAsByte...))
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// checkReceipt looks up the receipt of txnId before a request is applied. It returns the stored
+// receipt for an identical retry and a CONFLICT error when txnId was already used for a different
+// request; for a new txnId the receipt is nil. The request hash is returned for putReceipt.
+func checkReceipt(ctx contractapi.TransactionContextInterface, txnId string, function string, input interface{}) (*RECEIPT, string, error) {
+	requestHash, err := hashRequest(function, input)
+	if err != nil {
+		return nil, "", err
+	}
+
+	receipt, err := getReceipt(ctx, txnId)
+	if err != nil {
+		return nil, "", err
+	}
+	if receipt == nil {
+		return nil, requestHash, nil
+	}
+	if receipt.Function != function || receipt.RequestHash != requestHash {
+		return nil, "", newError(ERRCONFLICT, "transaction %s was already used for a different request", txnId).
+			withDetail("txnId", txnId).
+			withDetail("fabricTxId", receipt.FabricTxID)
+	}
+
+	fmt.Println("Returning receipt of transaction", txnId)
+	return receipt, requestHash, nil
+}
+
+// putReceipt stores the receipt of a request applied by the current transaction.
+func putReceipt(ctx contractapi.TransactionContextInterface, txnId string, function string, requestHash string, result []EVENTLEG) (*RECEIPT, error) {
+	var receipt RECEIPT
+	receipt.TxnID = txnId
+	receipt.Function = function
+	receipt.RequestHash = requestHash
+	receipt.FabricTxID = ctx.GetStub().GetTxID()
+	receipt.Result = result
+	receipt.DocType = RECEIPTDOC
+
+	var err error
+	receipt.SubmittedBy, err = getCallerUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	receipt.Timestamp, err = getRecordTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	receiptKey, err := ctx.GetStub().CreateCompositeKey(RECEIPTINDEX, []string{txnId})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key for receipt: %w", err)
+	}
+
+	receiptAsByte, err := json.Marshal(receipt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal receipt: %w", err)
+	}
+
+	err = ctx.GetStub().PutState(receiptKey, receiptAsByte)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store receipt state: %v", err)
+	}
+
+	return &receipt, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func TestHashRequestIgnoresAmountEncoding(t *testing.T) {
+	var legacy, typed FOODIE
+	if err := decodeInput(`{"TxnId": "m1", "Id": "MEAL", "UserId": "alice", "Amount": 100}`, &legacy); err != nil {
+		t.Fatal(err)
+	}
+	if err := decodeInput(`{"TxnId": "m1", "Id": "MEAL", "UserId": "alice", "Amount": "0100"}`, &typed); err != nil {
+		t.Fatal(err)
+	}
+
+	legacyHash, err := hashRequest(MINTEVENT, &legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	typedHash, err := hashRequest(MINTEVENT, &typed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if legacyHash != typedHash {
+		t.Fatal("expected identical requests to hash alike")
+	}
+
+	burnHash, err := hashRequest(BURNEVENT, &typed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if burnHash == typedHash {
+		t.Fatal("expected the function to be part of the hash")
+	}
+}
+
+func TestCheckReceiptReturnsReceiptOrConflict(t *testing.T) {
+	ledger := newBalanceTestLedger(t)
+	request := &FOODIE{TxnID: "m1", ID: "MEAL", UserId: "alice", Amount: "100"}
+	requestHash, err := hashRequest(MINTEVENT, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ledger.run(func(ctx contractapi.TransactionContextInterface) error {
+		receiptKey, err := ctx.GetStub().CreateCompositeKey(RECEIPTINDEX, []string{"m1"})
+		if err != nil {
+			return err
+		}
+		receiptAsByte, err := json.Marshal(RECEIPT{TxnID: "m1", Function: MINTEVENT, RequestHash: requestHash, FabricTxID: "tx1", DocType: RECEIPTDOC})
+		if err != nil {
+			return err
+		}
+		return ctx.GetStub().PutState(receiptKey, receiptAsByte)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ledger.run(func(ctx contractapi.TransactionContextInterface) error {
+		receipt, _, err := checkReceipt(ctx, "m1", MINTEVENT, request)
+		if err != nil {
+			return err
+		}
+		if receipt == nil || receipt.FabricTxID != "tx1" {
+			t.Fatalf("expected the stored receipt, got %v", receipt)
+		}
+
+		receipt, _, err = checkReceipt(ctx, "m2", MINTEVENT, request)
+		if err != nil {
+			return err
+		}
+		if receipt != nil {
+			t.Fatalf("expected no receipt for a new TxnId, got %v", receipt)
+		}
+
+		_, _, err = checkReceipt(ctx, "m1", MINTEVENT, &FOODIE{TxnID: "m1", ID: "MEAL", UserId: "alice", Amount: "50"})
+		if decoded := decodeChaincodeError(t, err); decoded.Code != ERRCONFLICT {
+			t.Fatalf("expected code %s, got %s", ERRCONFLICT, decoded.Code)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTransferBatchRetryWhilePausedReturnsReceipt(t *testing.T) {
+	ledger := newBalanceTestLedger(t)
+	ledger.createToken(TOKENDEF{ID: "MEAL", IssuerOrg: "Org1MSP", Transferable: true, DocType: TOKEN})
+	ledger.registerAccount("canteen", MERCHANTROLE)
+	ledger.registerAccount("alice")
+	if err := ledger.add("canteen", "MEAL", "10"); err != nil {
+		t.Fatal(err)
+	}
+
+	transferBatch := func() (*RECEIPT, error) {
+		var receipt *RECEIPT
+		err := ledger.runAs(newTestIdentity("canteen"), func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			receipt, err = new(SmartContract).TransferBatch(ctx, `{"TxnId": "b1", "UserId": "canteen", "Legs": [{"Receiver": "alice", "Id": "MEAL", "Amount": "4"}]}`)
+			return err
+		})
+		return receipt, err
+	}
+
+	first, err := transferBatch()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The retry gets its original outcome even though the token was paused since
+	ledger.setPaused("MEAL", true)
+	retry, err := transferBatch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retry.FabricTxID != first.FabricTxID {
+		t.Fatalf("expected the retry to return the original receipt, got %s and %s", first.FabricTxID, retry.FabricTxID)
+	}
+	if balance := ledger.balance("alice", "MEAL"); balance != "4" {
+		t.Fatalf("expected balance 4, got %s", balance)
+	}
+}
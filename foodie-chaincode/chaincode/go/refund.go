@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// REFUND is the record stored for every Refund: Amount of token Id moved back from UserId, the
+// account that was paid by OriginalTxnId, to Receiver, the account that paid.
+type REFUND struct {
+	TxnID         string `json:"TxnId"`
+	ID            string `json:"Id"`
+	DocType       string `json:"DocType"`
+	OriginalTxnID string `json:"OriginalTxnId"`
+	UserId        string `json:"UserId"`
+	Receiver      string `json:"Receiver"`
+	MerchantID    string `json:"MerchantId,omitempty" metadata:"MerchantId,optional"`
+	Amount        AMOUNT `json:"Amount"`
+	Reason        string `json:"Reason"`
+	RefundedBy    string `json:"RefundedBy"`
+	Timestamp     string `json:"Timestamp"`
+}
+
+// TRANSFERRECORD is a stored TRANSFERTXN together with the refunds Refund linked to it.
+type TRANSFERRECORD struct {
+	TRANSFER
+	Refunded AMOUNT   `json:"Refunded,omitempty"`
+	Refunds  []string `json:"Refunds,omitempty"`
+}
+
+const REFUNDTXN = "REFUNDTXN"
+const REFUNDEVENT = "Refund"
+
+// Refund pays back amount of the transfer or payment originalTxnId to the account that made it;
+// the legs of a TransferBatch are refunded as <TxnId>#<n>. Refunds may be partial, as long as
+// together they do not exceed the original amount. The refund is stored as a REFUNDTXN under the
+// client transaction id txnId and pointing at originalTxnId, and the original record keeps the
+// refunded total and the ids of its refunds. Only the merchant
+// that was paid, i.e. the owner of the receiving account holding the Merchant role, or an Admin
+// can refund. Retries are handled like Mint.
+func (s *SmartContract) Refund(ctx contractapi.TransactionContextInterface, txnId string, originalTxnId string, amount string, reason string) (*RECEIPT, error) {
+	refundAmount, err := newAmount(amount)
+	if err != nil {
+		return nil, err
+	}
+
+	var v inputValidator
+	v.requireTxnID("TxnId", txnId)
+	v.requireRecordTxnID("OriginalTxnId", originalTxnId)
+	v.requirePositiveAmount("Amount", refundAmount)
+	v.requireText("Reason", reason)
+	err = v.err()
+	if err != nil {
+		return nil, err
+	}
+
+	originalKey, original, err := getRefundableRecord(ctx, originalTxnId)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ensure the caller is the merchant that was paid, or an Admin
+	err = assertAccountOwner(ctx, original.Receiver)
+	if err == nil {
+		_, err = assertCallerRole(ctx, MERCHANTROLE)
+	}
+	if err != nil {
+		_, err = assertCallerRole(ctx, ADMINROLE)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Return the original receipt if this request was already applied
+	request := REFUND{TxnID: txnId, OriginalTxnID: originalTxnId, Amount: refundAmount, Reason: reason}
+	receipt, requestHash, err := checkReceipt(ctx, txnId, REFUNDEVENT, &request)
+	if err != nil {
+		return nil, err
+	}
+	if receipt != nil {
+		return receipt, nil
+	}
+
+	// Refuse the request while the contract or the token is paused
+	err = assertNotPaused(ctx, original.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = getRegisteredToken(ctx, original.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ensure the refunds stay within the original amount
+	originalAmount := original.Amount
+	if original.DocType == PAYTXN {
+		originalAmount = original.Total
+	}
+	refundable, err := subAmounts(originalAmount, original.Refunded)
+	if err != nil {
+		return nil, err
+	}
+	sign, err := compareAmounts(refundAmount, refundable)
+	if err != nil {
+		return nil, err
+	}
+	if sign > 0 {
+		return nil, newError(ERRINVALIDINPUT, "refund of %s exceeds the refundable amount %s of transaction %s", refundAmount, refundable, originalTxnId).
+			withDetail("originalTxnId", originalTxnId).
+			withDetail("refundable", string(refundable))
+	}
+
+	var refund REFUND
+	refund.TxnID = txnId
+	refund.ID = original.ID
+	refund.DocType = REFUNDTXN
+	refund.OriginalTxnID = originalTxnId
+	refund.UserId = original.Receiver
+	refund.Receiver = original.UserID
+	refund.MerchantID = original.MerchantID
+	refund.Amount = refundAmount
+	refund.Reason = reason
+	refund.RefundedBy, err = getCallerUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	refund.Timestamp, err = getRecordTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create a composite key for the refund
+	indexName := "TxnID~" + DOCTYPE
+	TxnCompositeKey, err := ctx.GetStub().CreateCompositeKey(indexName, []string{refund.TxnID, refund.ID})
+	if err != nil {
+		return nil, err
+	}
+
+	// Check for duplicate transactions
+	checkTxnDuplication, err := ctx.GetStub().GetState(TxnCompositeKey)
+	if err != nil {
+		return nil, fmt.Errorf("error checking transaction duplication: %w", err)
+	}
+	if checkTxnDuplication != nil {
+		return nil, newError(ERRDUPLICATE, "duplicate transaction")
+	}
+
+	// Move the amount back to the account that paid
+	err = removeBalance(ctx, refund.UserId, refund.ID, refund.Amount)
+	if err != nil {
+		return nil, err
+	}
+
+	err = addBalance(ctx, refund.Receiver, refund.ID, refund.Amount)
+	if err != nil {
+		return nil, err
+	}
+
+	err = moveLots(ctx, refund.UserId, refund.Receiver, refund.ID, refund.Amount)
+	if err != nil {
+		return nil, err
+	}
+
+	refundAsByte, err := json.Marshal(refund)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal refund: %w", err)
+	}
+
+	err = ctx.GetStub().PutState(TxnCompositeKey, refundAsByte)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store composite key state: %v", err)
+	}
+
+	err = indexTxn(ctx, TxnCompositeKey, refund.ID, refund.UserId, refund.Receiver, refund.Timestamp, refund.TxnID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Link the refund from the original record
+	err = linkRefund(ctx, originalKey, original.DocType, &refund)
+	if err != nil {
+		return nil, err
+	}
+
+	// Emit the refund event
+	legs := []EVENTLEG{{ID: refund.ID, From: refund.UserId, To: refund.Receiver, Amount: refund.Amount}}
+	err = emitEvent(ctx, REFUNDEVENT, refund.TxnID, legs)
+	if err != nil {
+		return nil, err
+	}
+
+	return putReceipt(ctx, refund.TxnID, REFUNDEVENT, requestHash, legs)
+}
+
+// linkRefund adds refund to the refunded total and the refund ids of the original record stored
+// under originalKey. The record is rewritten as the SALE or TRANSFER it was stored as, so its
+// document keeps its shape.
+func linkRefund(ctx contractapi.TransactionContextInterface, originalKey string, docType string, refund *REFUND) error {
+	originalAsByte, err := ctx.GetStub().GetState(originalKey)
+	if err != nil {
+		return fmt.Errorf("failed to fetch transaction record: %w", err)
+	}
+
+	if docType == PAYTXN {
+		var sale SALE
+		err = json.Unmarshal(originalAsByte, &sale)
+		if err != nil {
+			return fmt.Errorf("failed to unmarshal payment: %w", err)
+		}
+		sale.Refunded, err = addAmounts(sale.Refunded, refund.Amount)
+		if err != nil {
+			return err
+		}
+		sale.Refunds = append(sale.Refunds, refund.TxnID)
+		originalAsByte, err = json.Marshal(sale)
+	} else {
+		var transfer TRANSFERRECORD
+		err = json.Unmarshal(originalAsByte, &transfer)
+		if err != nil {
+			return fmt.Errorf("failed to unmarshal transfer: %w", err)
+		}
+		transfer.Refunded, err = addAmounts(transfer.Refunded, refund.Amount)
+		if err != nil {
+			return err
+		}
+		transfer.Refunds = append(transfer.Refunds, refund.TxnID)
+		originalAsByte, err = json.Marshal(transfer)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to marshal transaction record: %w", err)
+	}
+
+	err = ctx.GetStub().PutState(originalKey, originalAsByte)
+	if err != nil {
+		return fmt.Errorf("failed to store transaction record: %v", err)
+	}
+
+	return nil
+}
+
+// getRefundableRecord reads the TRANSFERTXN or PAYTXN record stored for txnId and its key.
+func getRefundableRecord(ctx contractapi.TransactionContextInterface, txnId string) (string, *LEDGERRECORD, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("TxnID~"+DOCTYPE, []string{txnId})
+	if err != nil {
+		return "", nil, err
+	}
+	defer resultsIterator.Close()
+
+	var recordKey string
+	var record *LEDGERRECORD
+	for resultsIterator.HasNext() {
+		queryResult, err := resultsIterator.Next()
+		if err != nil {
+			return "", nil, err
+		}
+
+		var candidate LEDGERRECORD
+		err = json.Unmarshal(queryResult.Value, &candidate)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to unmarshal transaction record: %w", err)
+		}
+		if candidate.DocType != TRANSFERTXN && candidate.DocType != PAYTXN {
+			continue
+		}
+		if record != nil {
+			return "", nil, newError(ERRCONFLICT, "transaction %s moved more than one token", txnId).withDetail("txnId", txnId)
+		}
+		recordKey = queryResult.Key
+		record = &candidate
+	}
+
+	if record == nil {
+		return "", nil, newError(ERRNOTFOUND, "no transfer or payment for transaction %s", txnId).withDetail("txnId", txnId)
+	}
+
+	return recordKey, record, nil
+}
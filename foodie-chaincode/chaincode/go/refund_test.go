@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func (l *balanceTestLedger) putTxnRecord(record interface{}, txnId string, id string) {
+	l.t.Helper()
+	err := l.run(func(ctx contractapi.TransactionContextInterface) error {
+		txnKey, err := ctx.GetStub().CreateCompositeKey("TxnID~"+DOCTYPE, []string{txnId, id})
+		if err != nil {
+			return err
+		}
+		recordAsByte, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		return ctx.GetStub().PutState(txnKey, recordAsByte)
+	})
+	if err != nil {
+		l.t.Fatal(err)
+	}
+}
+
+func TestGetRefundableRecordOnlyMatchesTransfersAndPayments(t *testing.T) {
+	ledger := newBalanceTestLedger(t)
+	ledger.putTxnRecord(FOODIE{TxnID: "m1", ID: "MEAL", DocType: MINTTXN, UserId: "u1", Amount: "10"}, "m1", "MEAL")
+	ledger.putTxnRecord(SALE{TxnID: "p1", ID: "MEAL", DocType: PAYTXN, UserId: "u1", Receiver: "merchant", Total: "9", Refunded: "4"}, "p1", "MEAL")
+
+	var record *LEDGERRECORD
+	err := ledger.run(func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		_, record, err = getRefundableRecord(ctx, "p1")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.DocType != PAYTXN || record.Total != "9" || record.Refunded != "4" {
+		t.Fatalf("unexpected record: %+v", record)
+	}
+
+	err = ledger.run(func(ctx contractapi.TransactionContextInterface) error {
+		_, _, err := getRefundableRecord(ctx, "m1")
+		return err
+	})
+	if err == nil {
+		t.Fatal("expected a mint not to be refundable")
+	}
+	if decoded := decodeChaincodeError(t, err); decoded.Code != ERRNOTFOUND {
+		t.Fatalf("expected code %s, got %s", ERRNOTFOUND, decoded.Code)
+	}
+}
+
+func TestRefundIsIdempotentAndKeepsTheTransferShape(t *testing.T) {
+	ledger := newBalanceTestLedger(t)
+	ledger.createToken(TOKENDEF{ID: "MEAL", IssuerOrg: "Org1MSP", Transferable: true, DocType: TOKEN})
+	ledger.registerAccount("alice", STUDENTROLE)
+	ledger.registerAccount("canteen", MERCHANTROLE)
+	if err := ledger.add("canteen", "MEAL", "10"); err != nil {
+		t.Fatal(err)
+	}
+	ledger.putTxnRecord(TRANSFER{TxnID: "t1", ID: "MEAL", DocType: TRANSFERTXN, UserId: "alice", Receiver: "canteen", Amount: "10"}, "t1", "MEAL")
+
+	refund := func(txnId string) (*RECEIPT, error) {
+		var receipt *RECEIPT
+		err := ledger.runAs(newTestIdentity("canteen"), func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			receipt, err = new(SmartContract).Refund(ctx, txnId, "t1", "6", "cold tea")
+			return err
+		})
+		return receipt, err
+	}
+
+	first, err := refund("r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	retry, err := refund("r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retry.FabricTxID != first.FabricTxID {
+		t.Fatalf("expected the retry to return the original receipt, got %s and %s", first.FabricTxID, retry.FabricTxID)
+	}
+	if balance := ledger.balance("alice", "MEAL"); balance != "6" {
+		t.Fatalf("expected balance 6, got %s", balance)
+	}
+
+	// A second refund under a new TxnId is capped by what is left
+	_, err = refund("r2")
+	assertErrorCode(t, err, ERRINVALIDINPUT)
+
+	// The transfer keeps its fields and gains the refund links only
+	txnKey, err := ledger.stub.CreateCompositeKey("TxnID~"+DOCTYPE, []string{"t1", "MEAL"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored map[string]interface{}
+	if err := json.Unmarshal(ledger.stub.State[txnKey], &stored); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := stored["Total"]; found {
+		t.Fatalf("expected a transfer document, got %v", stored)
+	}
+	if stored["Amount"] != "10" || stored["Refunded"] != "6" || len(stored["Refunds"].([]interface{})) != 1 {
+		t.Fatalf("unexpected transfer document: %v", stored)
+	}
+}
+
+func TestRefundOfATransferBatchLeg(t *testing.T) {
+	ledger := newBalanceTestLedger(t)
+	ledger.createToken(TOKENDEF{ID: "MEAL", IssuerOrg: "Org1MSP", Transferable: true, DocType: TOKEN})
+	ledger.registerAccount("alice", STUDENTROLE)
+	ledger.registerAccount("bob", STUDENTROLE)
+	ledger.registerAccount("canteen", MERCHANTROLE)
+	if err := ledger.add("alice", "MEAL", "10"); err != nil {
+		t.Fatal(err)
+	}
+
+	err := ledger.runAs(newTestIdentity("alice"), func(ctx contractapi.TransactionContextInterface) error {
+		_, err := new(SmartContract).TransferBatch(ctx, `{"TxnId": "b1", "UserId": "alice", "Legs": [{"Receiver": "bob", "Id": "MEAL", "Amount": "2"}, {"Receiver": "canteen", "Id": "MEAL", "Amount": "8"}]}`)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refund := func(txnId string, originalTxnId string) error {
+		return ledger.runAs(newTestIdentity("canteen"), func(ctx contractapi.TransactionContextInterface) error {
+			_, err := new(SmartContract).Refund(ctx, txnId, originalTxnId, "5", "wrong order")
+			return err
+		})
+	}
+
+	for _, originalTxnId := range []string{"b1#0", "b1#", "b1#x", "#2", "b 1#2"} {
+		assertErrorCode(t, refund("r0", originalTxnId), ERRINVALIDINPUT)
+	}
+	// The canteen was paid by the second leg only
+	assertErrorCode(t, refund("r1", "b1#1"), ERRUNAUTHORIZED)
+
+	if err := refund("r2", "b1#2"); err != nil {
+		t.Fatal(err)
+	}
+	if balance := ledger.balance("alice", "MEAL"); balance != "5" {
+		t.Fatalf("expected balance 5, got %s", balance)
+	}
+	if balance := ledger.balance("canteen", "MEAL"); balance != "3" {
+		t.Fatalf("expected balance 3, got %s", balance)
+	}
+
+	txnKey, err := ledger.stub.CreateCompositeKey("TxnID~"+DOCTYPE, []string{"b1#2", "MEAL"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored TRANSFERRECORD
+	if err := json.Unmarshal(ledger.stub.State[txnKey], &stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Refunded != "5" || len(stored.Refunds) != 1 || stored.Refunds[0] != "r2" {
+		t.Fatalf("expected the leg to record its refund, got %+v", stored)
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// ROLESTRUCT records a role granted to a registered foodie account.
+type ROLESTRUCT struct {
+	UserID    string `json:"UserId"`
+	Role      string `json:"Role"`
+	GrantedBy string `json:"GrantedBy"`
+	DocType   string `json:"DocType"`
+}
+
+// CONFIGSTRUCT marks the ledger as bootstrapped by InitLedger.
+type CONFIGSTRUCT struct {
+	AdminUserID string `json:"AdminUserId"`
+	DocType     string `json:"DocType"`
+}
+
+const ROLE = "ROLE"
+const CONFIG = "CONFIG"
+
+// ADMINMSPIDENV names the environment variable holding the MSPID allowed to run InitLedger.
+const ADMINMSPIDENV = "FOODIE_ADMIN_MSPID"
+
+const ADMINROLE = "Admin"
+const MINTERROLE = "Minter"
+const BURNERROLE = "Burner"
+const MERCHANTROLE = "Merchant"
+const STUDENTROLE = "Student"
+const AUDITORROLE = "Auditor"
+
+var validRoles = map[string]bool{
+	ADMINROLE:    true,
+	MINTERROLE:   true,
+	BURNERROLE:   true,
+	MERCHANTROLE: true,
+	STUDENTROLE:  true,
+	AUDITORROLE:  true,
+}
+
+// InitLedger bootstraps the role registry by registering the caller as adminUserId and granting it
+// the Admin role. It can only be run once per channel, and only by an identity of the admin MSP
+// configured in the FOODIE_ADMIN_MSPID environment variable of the chaincode.
+func (s *SmartContract) InitLedger(ctx contractapi.TransactionContextInterface, adminUserId string) error {
+	// Ensure the caller belongs to the configured admin org
+	adminMSPID := os.Getenv(ADMINMSPIDENV)
+	if adminMSPID == "" {
+		return newError(ERRUNAUTHORIZED, "%s is not configured, the ledger cannot be initialized", ADMINMSPIDENV)
+	}
+	clientMSPID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get MSPID: %w", err)
+	}
+	if clientMSPID != adminMSPID {
+		return newError(ERRUNAUTHORIZED, "client org %s is not the admin org %s", clientMSPID, adminMSPID)
+	}
+
+	configKey, err := ctx.GetStub().CreateCompositeKey(DOCTYPE+"~Config", []string{})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key for config: %w", err)
+	}
+
+	// Ensure the ledger has not been initialized already
+	checkConfigEntry, err := ctx.GetStub().GetState(configKey)
+	if err != nil {
+		return fmt.Errorf("failed to fetch config: %w", err)
+	}
+	if checkConfigEntry != nil {
+		return newError(ERRDUPLICATE, "ledger is already initialized")
+	}
+
+	// Register the caller's account unless it is already bound to adminUserId
+	callerAccount, err := lookupCallerAccount(ctx)
+	if err != nil {
+		return err
+	}
+	if callerAccount == nil {
+		err = s.RegisterAccount(ctx, adminUserId)
+		if err != nil {
+			return err
+		}
+	} else if callerAccount.UserID != adminUserId {
+		return newError(ERRDUPLICATE, "client identity is already bound to account %s", callerAccount.UserID)
+	}
+
+	err = putRole(ctx, adminUserId, ADMINROLE, adminUserId)
+	if err != nil {
+		return err
+	}
+
+	var config CONFIGSTRUCT
+	config.AdminUserID = adminUserId
+	config.DocType = CONFIG
+
+	configAsByte, err := json.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+
+	err = ctx.GetStub().PutState(configKey, configAsByte)
+	if err != nil {
+		return fmt.Errorf("failed to store config state: %v", err)
+	}
+
+	return nil
+}
+
+// GrantRole grants a role to a registered account. Only an Admin can grant roles.
+func (s *SmartContract) GrantRole(ctx contractapi.TransactionContextInterface, userId string, role string) error {
+	adminUserID, err := assertCallerRole(ctx, ADMINROLE)
+	if err != nil {
+		return err
+	}
+
+	if !validRoles[role] {
+		return newError(ERRINVALIDINPUT, "unknown role %s", role)
+	}
+
+	// Roles can only be held by accounts bound to an identity
+	account, err := getAccount(ctx, userId)
+	if err != nil {
+		return err
+	}
+	if account == nil {
+		return newError(ERRNOTFOUND, "account %s does not exist", userId)
+	}
+
+	return putRole(ctx, userId, role, adminUserID)
+}
+
+// RevokeRole removes a role from an account. Only an Admin can revoke roles and an Admin cannot
+// revoke its own Admin role.
+func (s *SmartContract) RevokeRole(ctx contractapi.TransactionContextInterface, userId string, role string) error {
+	adminUserID, err := assertCallerRole(ctx, ADMINROLE)
+	if err != nil {
+		return err
+	}
+
+	if role == ADMINROLE && userId == adminUserID {
+		return newError(ERRINVALIDINPUT, "admin cannot revoke its own admin role")
+	}
+
+	roleKey, err := ctx.GetStub().CreateCompositeKey(DOCTYPE+"~Role", []string{userId, role})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key for role: %w", err)
+	}
+
+	checkRoleEntry, err := ctx.GetStub().GetState(roleKey)
+	if err != nil {
+		return fmt.Errorf("failed to fetch role: %w", err)
+	}
+	if checkRoleEntry == nil {
+		return newError(ERRNOTFOUND, "account %s does not have role %s", userId, role)
+	}
+
+	err = ctx.GetStub().DelState(roleKey)
+	if err != nil {
+		return fmt.Errorf("failed to delete role state: %v", err)
+	}
+
+	return nil
+}
+
+// HasRole reports whether the account has been granted the role.
+func (s *SmartContract) HasRole(ctx contractapi.TransactionContextInterface, userId string, role string) (bool, error) {
+	return hasRole(ctx, userId, role)
+}
+
+func hasRole(ctx contractapi.TransactionContextInterface, userId string, role string) (bool, error) {
+	roleKey, err := ctx.GetStub().CreateCompositeKey(DOCTYPE+"~Role", []string{userId, role})
+	if err != nil {
+		return false, fmt.Errorf("failed to create composite key for role: %w", err)
+	}
+
+	checkRoleEntry, err := ctx.GetStub().GetState(roleKey)
+	if err != nil {
+		return false, fmt.Errorf("failed to fetch role: %w", err)
+	}
+
+	return checkRoleEntry != nil, nil
+}
+
+func putRole(ctx contractapi.TransactionContextInterface, userId string, role string, grantedBy string) error {
+	roleKey, err := ctx.GetStub().CreateCompositeKey(DOCTYPE+"~Role", []string{userId, role})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key for role: %w", err)
+	}
+
+	var roleStruct ROLESTRUCT
+	roleStruct.UserID = userId
+	roleStruct.Role = role
+	roleStruct.GrantedBy = grantedBy
+	roleStruct.DocType = ROLE
+
+	roleAsByte, err := json.Marshal(roleStruct)
+	if err != nil {
+		return fmt.Errorf("failed to marshal role: %w", err)
+	}
+
+	err = ctx.GetStub().PutState(roleKey, roleAsByte)
+	if err != nil {
+		return fmt.Errorf("failed to store role state: %v", err)
+	}
+
+	return nil
+}
+
+// assertCallerRole resolves the caller's account and fails unless it holds at least one of roles.
+// It returns the caller's UserId.
+func assertCallerRole(ctx contractapi.TransactionContextInterface, roles ...string) (string, error) {
+	callerUserID, err := getCallerUserID(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	for _, role := range roles {
+		ok, err := hasRole(ctx, callerUserID, role)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return callerUserID, nil
+		}
+	}
+
+	return "", newError(ERRUNAUTHORIZED, "account %s is not authorized, requires one of roles %v", callerUserID, roles)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func TestInitLedgerOnlyAcceptsTheConfiguredAdminOrg(t *testing.T) {
+	ledger := newBalanceTestLedger(t)
+	initLedger := func(identity *testIdentity) error {
+		return ledger.runAs(identity, func(ctx contractapi.TransactionContextInterface) error {
+			return new(SmartContract).InitLedger(ctx, identity.EnrollmentID)
+		})
+	}
+
+	t.Setenv(ADMINMSPIDENV, "")
+	assertErrorCode(t, initLedger(newTestIdentity("admin")), ERRUNAUTHORIZED)
+
+	t.Setenv(ADMINMSPIDENV, "Org1MSP")
+	outsider := newTestIdentity("mallory")
+	outsider.MSPID = "Org2MSP"
+	assertErrorCode(t, initLedger(outsider), ERRUNAUTHORIZED)
+
+	if err := initLedger(newTestIdentity("admin")); err != nil {
+		t.Fatal(err)
+	}
+	assertErrorCode(t, initLedger(newTestIdentity("admin")), ERRDUPLICATE)
+
+	err := ledger.run(func(ctx contractapi.TransactionContextInterface) error {
+		isAdmin, err := hasRole(ctx, "admin", ADMINROLE)
+		if err == nil && !isAdmin {
+			t.Fatal("expected admin to hold the Admin role")
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// STATEMENTLINE is one transaction on a statement. Amount is negative for debits and Balance is
+// the running balance after the transaction.
+type STATEMENTLINE struct {
+	TxnID        string `json:"TxnId"`
+	DocType      string `json:"DocType"`
+	Timestamp    string `json:"Timestamp"`
+	Counterparty string `json:"Counterparty"`
+	Amount       AMOUNT `json:"Amount"`
+	Balance      AMOUNT `json:"Balance"`
+}
+
+type STATEMENT struct {
+	UserID         string          `json:"UserId"`
+	ID             string          `json:"Id"`
+	From           string          `json:"From"`
+	To             string          `json:"To"`
+	OpeningBalance AMOUNT          `json:"OpeningBalance"`
+	ClosingBalance AMOUNT          `json:"ClosingBalance"`
+	Lines          []STATEMENTLINE `json:"Lines"`
+}
+
+const SENDERINDEX = DOCTYPE + "~Sender"
+const RECEIVERINDEX = DOCTYPE + "~Receiver"
+
+// SUPPLYTXNINDEX lists the mints and burns of a token, so the supply audit does not scan every
+// transaction record.
+const SUPPLYTXNINDEX = DOCTYPE + "~SupplyTxn"
+
+// GetStatement returns the chronologically ordered transactions of user for token id between from
+// and to (RFC3339, inclusive, either may be empty), with the opening, running and closing balance.
+// Transactions recorded before the statement index existed are not listed; their net effect is
+// carried in the opening balance, which is derived from the current balance.
+func (s *SmartContract) GetStatement(ctx contractapi.TransactionContextInterface, user string, id string, from string, to string) (*STATEMENT, error) {
+	// Only the account owner, an Admin or an Auditor can read a statement
+	err := assertAccountOwner(ctx, user)
+	if err != nil {
+		_, err = assertCallerRole(ctx, ADMINROLE, AUDITORROLE)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	statement := &STATEMENT{UserID: user, ID: id, OpeningBalance: "0", Lines: []STATEMENTLINE{}}
+	if from != "" {
+		statement.From, err = parseRecordTime(from)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if to != "" {
+		statement.To, err = parseRecordTime(to)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Collect every transaction the user sent or received
+	debits, err := getStatementLines(ctx, SENDERINDEX, user, id, -1)
+	if err != nil {
+		return nil, err
+	}
+	credits, err := getStatementLines(ctx, RECEIVERINDEX, user, id, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := append(debits, credits...)
+	sort.SliceStable(lines, func(i, j int) bool {
+		if lines[i].Timestamp != lines[j].Timestamp {
+			return lines[i].Timestamp < lines[j].Timestamp
+		}
+		return lines[i].TxnID < lines[j].TxnID
+	})
+
+	// Whatever the current balance does not explain through indexed transactions was moved by
+	// unindexed ones before them
+	currentBalance, err := getOwnerBalance(ctx, user, id)
+	if err != nil {
+		return nil, err
+	}
+	balance, err := parseAmount(currentBalance)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range lines {
+		amount, err := parseAmount(line.Amount)
+		if err != nil {
+			return nil, err
+		}
+		balance.Sub(balance, amount)
+	}
+	statement.OpeningBalance = toAmount(balance)
+
+	// Fold transactions before the period into the opening balance
+	for _, line := range lines {
+		if statement.To != "" && line.Timestamp > statement.To {
+			break
+		}
+
+		amount, err := parseAmount(line.Amount)
+		if err != nil {
+			return nil, err
+		}
+		balance.Add(balance, amount)
+		if statement.From != "" && line.Timestamp < statement.From {
+			statement.OpeningBalance = toAmount(balance)
+			continue
+		}
+
+		line.Balance = toAmount(balance)
+		statement.Lines = append(statement.Lines, line)
+	}
+	statement.ClosingBalance = toAmount(balance)
+
+	return statement, nil
+}
+
+// getStatementLines reads the sender or receiver index of user for token id. Amounts are negated
+// when sign is negative.
+func getStatementLines(ctx contractapi.TransactionContextInterface, indexName string, user string, id string, sign int) ([]STATEMENTLINE, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(indexName, []string{user, id})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var lines []STATEMENTLINE
+	for resultsIterator.HasNext() {
+		queryResult, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		// The index entry points at the transaction record
+		recordAsByte, err := ctx.GetStub().GetState(string(queryResult.Value))
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch transaction record: %w", err)
+		}
+		if recordAsByte == nil {
+			return nil, fmt.Errorf("transaction record for index entry %s does not exist", queryResult.Key)
+		}
+
+		var record LEDGERRECORD
+		err = json.Unmarshal(recordAsByte, &record)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal transaction record: %w", err)
+		}
+
+		line := STATEMENTLINE{TxnID: record.TxnID, DocType: record.DocType, Timestamp: record.Timestamp, Amount: record.Amount}
+		switch record.DocType {
+		case BURN:
+			line.Amount = record.BurnTokenAmount
+		case PAYTXN:
+			line.Amount = record.Total
+			line.Counterparty = record.Receiver
+			if sign > 0 {
+				line.Counterparty = record.UserID
+			}
+		case TRANSFERTXN, REASSIGNTXN, REFUNDTXN:
+			line.Counterparty = record.Receiver
+			if sign > 0 {
+				line.Counterparty = record.UserID
+			}
+		}
+		if sign < 0 {
+			line.Amount, err = negateAmount(line.Amount)
+			if err != nil {
+				return nil, err
+			}
+		}
+		lines = append(lines, line)
+	}
+
+	return lines, nil
+}
+
+// indexTxn adds the sender and receiver index entries pointing at the transaction record stored
+// under txnKey. Mints have no sender and burns have no receiver; both are also added to the supply
+// transaction index of token id.
+func indexTxn(ctx contractapi.TransactionContextInterface, txnKey string, id string, sender string, receiver string, timestamp string, txnId string) error {
+	if sender != "" {
+		senderKey, err := ctx.GetStub().CreateCompositeKey(SENDERINDEX, []string{sender, id, timestamp, txnId})
+		if err != nil {
+			return fmt.Errorf("failed to create composite key for sender index: %w", err)
+		}
+
+		err = ctx.GetStub().PutState(senderKey, []byte(txnKey))
+		if err != nil {
+			return fmt.Errorf("failed to store sender index: %v", err)
+		}
+	}
+
+	if receiver != "" {
+		receiverKey, err := ctx.GetStub().CreateCompositeKey(RECEIVERINDEX, []string{receiver, id, timestamp, txnId})
+		if err != nil {
+			return fmt.Errorf("failed to create composite key for receiver index: %w", err)
+		}
+
+		err = ctx.GetStub().PutState(receiverKey, []byte(txnKey))
+		if err != nil {
+			return fmt.Errorf("failed to store receiver index: %v", err)
+		}
+	}
+
+	if sender == "" || receiver == "" {
+		supplyKey, err := ctx.GetStub().CreateCompositeKey(SUPPLYTXNINDEX, []string{id, timestamp, txnId})
+		if err != nil {
+			return fmt.Errorf("failed to create composite key for supply transaction index: %w", err)
+		}
+
+		err = ctx.GetStub().PutState(supplyKey, []byte(txnKey))
+		if err != nil {
+			return fmt.Errorf("failed to store supply transaction index: %v", err)
+		}
+	}
+
+	return nil
+}
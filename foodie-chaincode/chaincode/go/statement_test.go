@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func TestGetStatementOpensWithBalanceFromUnindexedRecords(t *testing.T) {
+	ledger := newBalanceTestLedger(t)
+	ledger.createToken(TOKENDEF{ID: "MEAL", IssuerOrg: "Org1MSP", Transferable: true, DocType: TOKEN})
+	ledger.registerAccount("alice", STUDENTROLE)
+	ledger.registerAccount("bob", STUDENTROLE)
+
+	// A balance credited before the statement index existed
+	if err := ledger.add("alice", "MEAL", "50"); err != nil {
+		t.Fatal(err)
+	}
+
+	err := ledger.runAs(newTestIdentity("alice"), func(ctx contractapi.TransactionContextInterface) error {
+		_, err := new(SmartContract).TransferTyped(ctx, TRANSFER{TxnID: "t1", ID: "MEAL", UserId: "alice", Receiver: "bob", Amount: "5"})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var statement *STATEMENT
+	err = ledger.runAs(newTestIdentity("alice"), func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		statement, err = new(SmartContract).GetStatement(ctx, "alice", "MEAL", "", "")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if statement.OpeningBalance != "50" || statement.ClosingBalance != "45" {
+		t.Fatalf("expected opening 50 and closing 45, got %s and %s", statement.OpeningBalance, statement.ClosingBalance)
+	}
+	if len(statement.Lines) != 1 || statement.Lines[0].Amount != "-5" || statement.Lines[0].Balance != "45" {
+		t.Fatalf("unexpected lines: %+v", statement.Lines)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// TOKENDEF describes a token class (meal credits, snack credits, event vouchers, ...).
+// Amounts are integers in base units; Decimals tells clients where to place the decimal point.
+// A MaxSupply of zero means the supply is uncapped. DeltaWrites tokens record credits and supply
+// changes as per-transaction delta keys so hot accounts do not hit MVCC conflicts; it cannot be
+// changed after the token is created. UTXO tokens hold balances as unspent outputs instead of
+// owner entries; a token cannot use both. ValidFrom and ValidUntil (RFC3339, either may be empty)
+// bound when the token can be minted. Expiring tokens also track every mint as a lot with its own
+// expiry, which defaults to ValidUntil; they cannot use the UTXO model or delta writes.
+type TOKENDEF struct {
+	ID           string `json:"Id"`
+	Name         string `json:"Name"`
+	Symbol       string `json:"Symbol"`
+	Decimals     int    `json:"Decimals"`
+	IssuerOrg    string `json:"IssuerOrg"`
+	MaxSupply    AMOUNT `json:"MaxSupply"`
+	MetadataURI  string `json:"MetadataURI"`
+	Transferable bool   `json:"Transferable"`
+	DeltaWrites  bool   `json:"DeltaWrites"`
+	UTXO         bool   `json:"UTXO"`
+	ValidFrom    string `json:"ValidFrom"`
+	ValidUntil   string `json:"ValidUntil"`
+	Expiring     bool   `json:"Expiring"`
+	CreatedBy    string `json:"CreatedBy"`
+	DocType      string `json:"DocType"`
+}
+
+const TOKEN = "TOKEN"
+const MAXDECIMALS = 18
+
+// CreateToken registers a new token class. Only an Admin can create tokens. IssuerOrg defaults to
+// the caller's MSPID and is the only org allowed to mint the token.
+func (s *SmartContract) CreateToken(ctx contractapi.TransactionContextInterface, input string) error {
+	// Unmarshal the input JSON into a token definition
+	var tokenInput TOKENDEF
+	err := decodeInput(input, &tokenInput)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Unmarshaled input data:", tokenInput)
+
+	// Ensure only an Admin can register tokens
+	admin, err := assertCallerRole(ctx, ADMINROLE)
+	if err != nil {
+		return err
+	}
+
+	// Validate the token definition
+	if tokenInput.ID == "" || tokenInput.Name == "" || tokenInput.Symbol == "" {
+		return newError(ERRINVALIDINPUT, "token id, name and symbol must not be empty")
+	}
+	if tokenInput.Decimals < 0 || tokenInput.Decimals > MAXDECIMALS {
+		return newError(ERRINVALIDINPUT, "token decimals must be between 0 and %d", MAXDECIMALS)
+	}
+	maxSupply, err := parseAmount(tokenInput.MaxSupply)
+	if err != nil {
+		return err
+	}
+	if maxSupply.Sign() < 0 {
+		return newError(ERRINVALIDINPUT, "token max supply must not be negative")
+	}
+	if tokenInput.UTXO && tokenInput.DeltaWrites {
+		return newError(ERRINVALIDINPUT, "token cannot use both the UTXO model and delta writes")
+	}
+	if tokenInput.Expiring && (tokenInput.UTXO || tokenInput.DeltaWrites) {
+		return newError(ERRINVALIDINPUT, "expiring token cannot use the UTXO model or delta writes")
+	}
+	if tokenInput.ValidFrom != "" {
+		tokenInput.ValidFrom, err = parseRecordTime(tokenInput.ValidFrom)
+		if err != nil {
+			return err
+		}
+	}
+	if tokenInput.ValidUntil != "" {
+		tokenInput.ValidUntil, err = parseRecordTime(tokenInput.ValidUntil)
+		if err != nil {
+			return err
+		}
+	}
+	if tokenInput.ValidFrom != "" && tokenInput.ValidUntil != "" && tokenInput.ValidFrom >= tokenInput.ValidUntil {
+		return newError(ERRINVALIDINPUT, "token validity must end after it starts")
+	}
+
+	if tokenInput.IssuerOrg == "" {
+		tokenInput.IssuerOrg, err = ctx.GetClientIdentity().GetMSPID()
+		if err != nil {
+			return fmt.Errorf("failed to get MSPID: %w", err)
+		}
+	}
+
+	// Ensure the token id has not already been registered
+	existingToken, err := getToken(ctx, tokenInput.ID)
+	if err != nil {
+		return err
+	}
+	if existingToken != nil {
+		return newError(ERRDUPLICATE, "token %s already exists", tokenInput.ID)
+	}
+
+	tokenInput.CreatedBy = admin
+	tokenInput.DocType = TOKEN
+
+	tokenKey, err := ctx.GetStub().CreateCompositeKey(DOCTYPE+"~Token", []string{tokenInput.ID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key for token: %w", err)
+	}
+
+	tokenAsByte, err := json.Marshal(tokenInput)
+	if err != nil {
+		return fmt.Errorf("failed to marshal token: %w", err)
+	}
+
+	err = ctx.GetStub().PutState(tokenKey, tokenAsByte)
+	if err != nil {
+		return fmt.Errorf("failed to store token state: %v", err)
+	}
+
+	// Create the supply record unless it was already created by earlier mints
+	forTotalSupply, err := ctx.GetStub().GetState(tokenInput.ID)
+	if err != nil {
+		return err
+	}
+
+	if forTotalSupply == nil {
+		var supply FOODIE
+		supply.ID = tokenInput.ID
+		supply.OrgName = tokenInput.IssuerOrg
+		supply.DocType = DOCTYPE
+		supply.Amount = "0"
+		supply.TotalSupply = "0"
+
+		foodieAsByte, err := json.Marshal(supply)
+		if err != nil {
+			return fmt.Errorf("failed to marshal foodie state: %w", err)
+		}
+
+		err = ctx.GetStub().PutState(supply.ID, foodieAsByte)
+		if err != nil {
+			return fmt.Errorf("failed to store foodie state: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// GetToken returns the definition of a registered token.
+func (s *SmartContract) GetToken(ctx contractapi.TransactionContextInterface, id string) (*TOKENDEF, error) {
+	return getRegisteredToken(ctx, id)
+}
+
+// getToken reads the token definition for id, returning nil if it has not been registered.
+func getToken(ctx contractapi.TransactionContextInterface, id string) (*TOKENDEF, error) {
+	tokenKey, err := ctx.GetStub().CreateCompositeKey(DOCTYPE+"~Token", []string{id})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key for token: %w", err)
+	}
+
+	tokenAsByte, err := ctx.GetStub().GetState(tokenKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch token: %w", err)
+	}
+	if tokenAsByte == nil {
+		return nil, nil
+	}
+
+	var token TOKENDEF
+	err = json.Unmarshal(tokenAsByte, &token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal token: %w", err)
+	}
+
+	return &token, nil
+}
+
+// getRegisteredToken is getToken but fails for unknown token ids.
+func getRegisteredToken(ctx contractapi.TransactionContextInterface, id string) (*TOKENDEF, error) {
+	token, err := getToken(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if token == nil {
+		return nil, newError(ERRNOTFOUND, "token %s is not registered", id)
+	}
+
+	return token, nil
+}
+
+// hasMaxSupply reports whether the supply of token is capped.
+func hasMaxSupply(token *TOKENDEF) bool {
+	return token.MaxSupply != "" && token.MaxSupply != "0"
+}
+
+// exceedsMaxSupply reports whether supply is past the cap of token.
+func exceedsMaxSupply(token *TOKENDEF, supply AMOUNT) (bool, error) {
+	if !hasMaxSupply(token) {
+		return false, nil
+	}
+
+	cmp, err := compareAmounts(supply, token.MaxSupply)
+	if err != nil {
+		return false, err
+	}
+
+	return cmp > 0, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// mint mints through the contract as the registered account minter.
+func (l *balanceTestLedger) mint(minter string, input FOODIE) (*RECEIPT, error) {
+	var receipt *RECEIPT
+	err := l.runAs(newTestIdentity(minter), func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		receipt, err = new(SmartContract).MintTyped(ctx, input)
+		return err
+	})
+	return receipt, err
+}
+
+func TestCreateTokenRegistersTokenOnce(t *testing.T) {
+	ledger := newBalanceTestLedger(t)
+	ledger.registerAccount("admin", ADMINROLE)
+	ledger.registerAccount("alice", STUDENTROLE)
+
+	createToken := func(caller string, input string) error {
+		return ledger.runAs(newTestIdentity(caller), func(ctx contractapi.TransactionContextInterface) error {
+			return new(SmartContract).CreateToken(ctx, input)
+		})
+	}
+
+	assertErrorCode(t, createToken("alice", `{"Id": "MEAL", "Name": "Meal credit", "Symbol": "MEAL"}`), ERRUNAUTHORIZED)
+	assertErrorCode(t, createToken("admin", `{"Id": "MEAL", "Name": "", "Symbol": "MEAL"}`), ERRINVALIDINPUT)
+	assertErrorCode(t, createToken("admin", `{"Id": "MEAL", "Name": "Meal credit", "Symbol": "MEAL", "UTXO": true, "DeltaWrites": true}`), ERRINVALIDINPUT)
+
+	if err := createToken("admin", `{"Id": "MEAL", "Name": "Meal credit", "Symbol": "MEAL", "MaxSupply": "100"}`); err != nil {
+		t.Fatal(err)
+	}
+	assertErrorCode(t, createToken("admin", `{"Id": "MEAL", "Name": "Other", "Symbol": "OTH"}`), ERRDUPLICATE)
+
+	err := ledger.run(func(ctx contractapi.TransactionContextInterface) error {
+		token, err := getRegisteredToken(ctx, "MEAL")
+		if err != nil {
+			return err
+		}
+		if token.IssuerOrg != "Org1MSP" || token.CreatedBy != "admin" || token.MaxSupply != "100" {
+			t.Errorf("unexpected token: %+v", token)
+		}
+		supply, err := getTotalSupply(ctx, "MEAL")
+		if err == nil && supply != "0" {
+			t.Errorf("expected total supply 0, got %s", supply)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMintChecksRegistryAndSupplyCap(t *testing.T) {
+	ledger := newBalanceTestLedger(t)
+	ledger.createToken(TOKENDEF{ID: "MEAL", IssuerOrg: "Org1MSP", MaxSupply: "100", Transferable: true, DocType: TOKEN})
+	ledger.registerAccount("minter", MINTERROLE)
+	ledger.registerAccount("alice", STUDENTROLE)
+
+	_, err := ledger.mint("minter", FOODIE{TxnID: "m1", ID: "SNACK", UserId: "alice", Amount: "10"})
+	assertErrorCode(t, err, ERRNOTFOUND)
+
+	_, err = ledger.mint("minter", FOODIE{TxnID: "m2", ID: "MEAL", UserId: "alice", Amount: "101"})
+	assertErrorCode(t, err, ERRINVALIDINPUT)
+
+	if _, err := ledger.mint("minter", FOODIE{TxnID: "m3", ID: "MEAL", UserId: "alice", Amount: "60"}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = ledger.mint("minter", FOODIE{TxnID: "m4", ID: "MEAL", UserId: "alice", Amount: "41"})
+	assertErrorCode(t, err, ERRINVALIDINPUT)
+
+	// Minting up to the cap itself is allowed
+	if _, err := ledger.mint("minter", FOODIE{TxnID: "m5", ID: "MEAL", UserId: "alice", Amount: "40"}); err != nil {
+		t.Fatal(err)
+	}
+	if balance := ledger.balance("alice", "MEAL"); balance != "100" {
+		t.Fatalf("expected balance 100, got %s", balance)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// UTXOSTRUCT is an unspent output of a UTXO token. An output is identified by its token id, owner
+// and the Fabric transaction that created it, and is deleted when it is spent.
+type UTXOSTRUCT struct {
+	ID      string `json:"Id"`
+	Owner   string `json:"Owner"`
+	TxID    string `json:"TxId"`
+	DocType string `json:"DocType"`
+	Amount  AMOUNT `json:"Amount"`
+}
+
+const UTXO = "UTXO"
+const UTXOINDEX = DOCTYPE + "~UTXO"
+
+// ListUnspent returns the unspent outputs of owner for token id in key order, which follows the
+// transaction id hash and says nothing about the age of an output.
+func (s *SmartContract) ListUnspent(ctx contractapi.TransactionContextInterface, owner string, id string) ([]*UTXOSTRUCT, error) {
+	// Only the account owner, an Admin or an Auditor can list outputs
+	err := assertAccountOwner(ctx, owner)
+	if err != nil {
+		_, err = assertCallerRole(ctx, ADMINROLE, AUDITORROLE)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	token, err := getRegisteredToken(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !token.UTXO {
+		return nil, newError(ERRINVALIDINPUT, "token %s does not use the UTXO model", id)
+	}
+
+	return getOutputs(ctx, owner, id)
+}
+
+// getOutputs reads every unspent output of owner for token id.
+func getOutputs(ctx contractapi.TransactionContextInterface, owner string, id string) ([]*UTXOSTRUCT, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(UTXOINDEX, []string{id, owner})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	outputs := []*UTXOSTRUCT{}
+	for resultsIterator.HasNext() {
+		queryResult, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var output UTXOSTRUCT
+		err = json.Unmarshal(queryResult.Value, &output)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal output: %w", err)
+		}
+		outputs = append(outputs, &output)
+	}
+
+	return outputs, nil
+}
+
+// getOutput reads the output of owner for token id created by txId, returning nil if it does not
+// exist or has been spent.
+func getOutput(ctx contractapi.TransactionContextInterface, owner string, id string, txId string) (*UTXOSTRUCT, error) {
+	outputKey, err := ctx.GetStub().CreateCompositeKey(UTXOINDEX, []string{id, owner, txId})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key for output: %w", err)
+	}
+
+	outputAsByte, err := ctx.GetStub().GetState(outputKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch output: %w", err)
+	}
+	if outputAsByte == nil {
+		return nil, nil
+	}
+
+	var output UTXOSTRUCT
+	err = json.Unmarshal(outputAsByte, &output)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal output: %w", err)
+	}
+
+	return &output, nil
+}
+
+// putOutput creates an output of amount for owner in the current transaction. Callers must
+// aggregate so that an owner receives at most one output per token per transaction.
+func putOutput(ctx contractapi.TransactionContextInterface, owner string, id string, amount AMOUNT) error {
+	txId := ctx.GetStub().GetTxID()
+	outputKey, err := ctx.GetStub().CreateCompositeKey(UTXOINDEX, []string{id, owner, txId})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key for output: %w", err)
+	}
+
+	outputAsByte, err := json.Marshal(UTXOSTRUCT{ID: id, Owner: owner, TxID: txId, DocType: UTXO, Amount: amount})
+	if err != nil {
+		return fmt.Errorf("failed to marshal output: %w", err)
+	}
+
+	err = ctx.GetStub().PutState(outputKey, outputAsByte)
+	if err != nil {
+		return fmt.Errorf("failed to store output state: %v", err)
+	}
+
+	return nil
+}
+
+// spendOutputs consumes outputs of owner for token id worth at least amount and returns the
+// difference to owner as a change output. inputs lists the TxIds of the outputs to spend; when it
+// is empty, outputs are picked in key order, by transaction id hash rather than by age.
+func spendOutputs(ctx contractapi.TransactionContextInterface, owner string, id string, amount AMOUNT, inputs []string) error {
+	required, err := parseAmount(amount)
+	if err != nil {
+		return err
+	}
+
+	var outputs []*UTXOSTRUCT
+	total := new(big.Int)
+	if len(inputs) == 0 {
+		unspent, err := getOutputs(ctx, owner, id)
+		if err != nil {
+			return err
+		}
+
+		for _, output := range unspent {
+			if total.Cmp(required) >= 0 {
+				break
+			}
+
+			value, err := parseAmount(output.Amount)
+			if err != nil {
+				return err
+			}
+			outputs = append(outputs, output)
+			total.Add(total, value)
+		}
+	} else {
+		seen := make(map[string]bool)
+		for _, txId := range inputs {
+			if seen[txId] {
+				return newError(ERRINVALIDINPUT, "output %s is listed more than once", txId)
+			}
+			seen[txId] = true
+
+			output, err := getOutput(ctx, owner, id, txId)
+			if err != nil {
+				return err
+			}
+			if output == nil {
+				return newError(ERRNOTFOUND, "output %s of owner %s is spent or does not exist", txId, owner)
+			}
+
+			value, err := parseAmount(output.Amount)
+			if err != nil {
+				return err
+			}
+			outputs = append(outputs, output)
+			total.Add(total, value)
+		}
+	}
+
+	if total.Cmp(required) < 0 {
+		return &InsufficientFundsError{UserID: owner, ID: id, Balance: toAmount(total), Amount: amount}
+	}
+
+	// Delete the spent outputs so they cannot be spent again
+	for _, output := range outputs {
+		outputKey, err := ctx.GetStub().CreateCompositeKey(UTXOINDEX, []string{id, owner, output.TxID})
+		if err != nil {
+			return fmt.Errorf("failed to create composite key for output: %w", err)
+		}
+
+		err = ctx.GetStub().DelState(outputKey)
+		if err != nil {
+			return fmt.Errorf("failed to delete output state: %v", err)
+		}
+	}
+
+	// Return the change to the owner
+	if total.Cmp(required) > 0 {
+		change, err := checkedAmount(total.Sub(total, required))
+		if err != nil {
+			return err
+		}
+		return putOutput(ctx, owner, id, change)
+	}
+
+	return nil
+}
+
+// sumOutputs returns the balance of owner for token id as the sum of its unspent outputs.
+func sumOutputs(ctx contractapi.TransactionContextInterface, owner string, id string) (AMOUNT, error) {
+	outputs, err := getOutputs(ctx, owner, id)
+	if err != nil {
+		return "", err
+	}
+
+	total := AMOUNT("0")
+	for _, output := range outputs {
+		total, err = addAmounts(total, output.Amount)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return total, nil
+}
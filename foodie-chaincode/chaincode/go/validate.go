@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"regexp"
+	"strings"
+)
+
+const MAXIDLENGTH = 64
+const MAXTXNIDLENGTH = 128
+const MAXTEXTLENGTH = 256
+
+// Ids become parts of composite keys, so they are limited to a conservative charset.
+var idPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.@:-]*$`)
+var txnIdPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+var legNumberPattern = regexp.MustCompile(`^[1-9][0-9]*$`)
+
+// decodeInput strictly unmarshals a transaction payload into v: unknown fields and trailing data
+// are rejected.
+func decodeInput(input string, v interface{}) error {
+	decoder := json.NewDecoder(strings.NewReader(input))
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(v)
+	if err != nil {
+		return wrapError(err, ERRINVALIDINPUT, "failed to unmarshal input")
+	}
+	if decoder.Decode(&json.RawMessage{}) != io.EOF {
+		return newError(ERRINVALIDINPUT, "failed to unmarshal input: unexpected data after the JSON object")
+	}
+
+	return nil
+}
+
+// inputValidator collects every validation failure of a payload so they are returned together.
+type inputValidator struct {
+	failures []string
+	fields   map[string]string
+}
+
+func (v *inputValidator) fail(field string, format string, args ...interface{}) {
+	reason := fmt.Sprintf(format, args...)
+	v.failures = append(v.failures, field+" "+reason)
+
+	if v.fields == nil {
+		v.fields = make(map[string]string)
+	}
+	if _, ok := v.fields[field]; !ok {
+		v.fields[field] = reason
+	}
+}
+
+// requireID checks that value is a non-empty id of at most MAXIDLENGTH characters.
+func (v *inputValidator) requireID(field string, value string) {
+	if value == "" {
+		v.fail(field, "must not be empty")
+		return
+	}
+	v.optionalID(field, value)
+}
+
+// optionalID is requireID for fields that may be left empty.
+func (v *inputValidator) optionalID(field string, value string) {
+	if value == "" {
+		return
+	}
+	if len(value) > MAXIDLENGTH {
+		v.fail(field, "must be at most %d characters", MAXIDLENGTH)
+	} else if !idPattern.MatchString(value) {
+		v.fail(field, "must start with a letter or digit and contain only letters, digits and _ . @ : -")
+	}
+}
+
+// requireTxnID checks that value is a non-empty client transaction id of at most MAXTXNIDLENGTH
+// letters, digits, underscores and hyphens.
+func (v *inputValidator) requireTxnID(field string, value string) {
+	if value == "" {
+		v.fail(field, "must not be empty")
+	} else if len(value) > MAXTXNIDLENGTH {
+		v.fail(field, "must be at most %d characters", MAXTXNIDLENGTH)
+	} else if !txnIdPattern.MatchString(value) {
+		v.fail(field, "must start with a letter or digit and contain only letters, digits, _ and -")
+	}
+}
+
+// requireRecordTxnID is requireTxnID but also accepts the <TxnId>#<n> form under which the legs of
+// a batch are recorded.
+func (v *inputValidator) requireRecordTxnID(field string, value string) {
+	if i := strings.LastIndex(value, "#"); i >= 0 {
+		if !legNumberPattern.MatchString(value[i+1:]) {
+			v.fail(field, "must end in # followed by a leg number")
+			return
+		}
+		value = value[:i]
+	}
+	v.requireTxnID(field, value)
+}
+
+// requireText checks that value is a non-empty free text of at most MAXTEXTLENGTH characters.
+func (v *inputValidator) requireText(field string, value string) {
+	if strings.TrimSpace(value) == "" {
+		v.fail(field, "must not be empty")
+	} else if len(value) > MAXTEXTLENGTH {
+		v.fail(field, "must be at most %d characters", MAXTEXTLENGTH)
+	}
+}
+
+// requirePositiveAmount checks that value is an amount greater than zero.
+func (v *inputValidator) requirePositiveAmount(field string, value AMOUNT) {
+	positive, err := isPositiveAmount(value)
+	if err != nil || !positive {
+		v.fail(field, "must be greater than zero")
+	}
+}
+
+// requireDistinct checks that two account fields do not name the same account.
+func (v *inputValidator) requireDistinct(field string, value string, otherField string, other string) {
+	if value != "" && value == other {
+		v.fail(field, "must be another account than %s", otherField)
+	}
+}
+
+// err returns every failure as one INVALID_INPUT error, or nil if the payload is valid. Details
+// maps each failing field to its first failure.
+func (v *inputValidator) err() error {
+	if len(v.failures) == 0 {
+		return nil
+	}
+
+	validationErr := newError(ERRINVALIDINPUT, "invalid input: %s", strings.Join(v.failures, "; "))
+	for field, reason := range v.fields {
+		validationErr.withDetail(field, reason)
+	}
+	return validationErr
+}
+
+func validateMintInput(input *FOODIE) error {
+	var v inputValidator
+	v.requireTxnID("TxnId", input.TxnID)
+	v.requireID("Id", input.ID)
+	v.requireID("UserId", input.UserId)
+	v.optionalID("OrgName", input.OrgName)
+	v.requirePositiveAmount("Amount", input.Amount)
+	return v.err()
+}
+
+func validateTransferInput(input *TRANSFER) error {
+	var v inputValidator
+	v.requireTxnID("TxnId", input.TxnID)
+	v.requireID("Id", input.ID)
+	v.requireID("UserId", input.UserId)
+	v.requireID("Receiver", input.Receiver)
+	v.requireDistinct("Receiver", input.Receiver, "UserId", input.UserId)
+	v.requirePositiveAmount("Amount", input.Amount)
+	for i, txId := range input.Inputs {
+		if txId == "" {
+			v.fail(fmt.Sprintf("Inputs[%d]", i), "must not be empty")
+		}
+	}
+	return v.err()
+}
+
+func validateBurnInput(input *BURNTOKEN) error {
+	var v inputValidator
+	v.requireTxnID("TxnId", input.TxnID)
+	v.requireID("Id", input.ID)
+	v.requireID("BurnTokenId", input.BurnTokenID)
+	v.optionalID("UserId", input.UserID)
+	v.optionalID("OrgName", input.OrgName)
+	v.requirePositiveAmount("BurnTokenAmount", input.BurnTokenAmount)
+	return v.err()
+}
+
+func validatePayInput(input *PAYMENT) error {
+	var v inputValidator
+	v.requireTxnID("TxnId", input.TxnID)
+	v.requireID("Id", input.ID)
+	v.requireID("UserId", input.UserId)
+	v.requireID("MerchantId", input.MerchantID)
+	v.requireText("OrderRef", input.OrderRef)
+	v.requirePositiveAmount("Total", input.Total)
+	if len(input.Items) == 0 {
+		v.fail("Items", "must contain at least one line")
+	}
+
+	// The total must be what the lines add up to
+	sum := new(big.Int)
+	for i, item := range input.Items {
+		field := fmt.Sprintf("Items[%d]", i)
+		v.requireText(field+".Description", item.Description)
+		if item.Quantity <= 0 {
+			v.fail(field+".Quantity", "must be greater than zero")
+		}
+		v.requirePositiveAmount(field+".UnitPrice", item.UnitPrice)
+
+		unitPrice, err := parseAmount(item.UnitPrice)
+		if err == nil && item.Quantity > 0 {
+			sum.Add(sum, new(big.Int).Mul(unitPrice, big.NewInt(int64(item.Quantity))))
+		}
+	}
+	total, err := parseAmount(input.Total)
+	if err == nil && len(v.failures) == 0 && sum.Cmp(total) != 0 {
+		v.fail("Total", "must equal the sum of the items, %s", sum.String())
+	}
+	return v.err()
+}
+
+func validateTransferBatchInput(input *TRANSFERBATCH) error {
+	var v inputValidator
+	v.requireTxnID("TxnId", input.TxnID)
+	v.requireID("UserId", input.UserId)
+	if len(input.Legs) == 0 {
+		v.fail("Legs", "must contain at least one leg")
+	}
+	for i, leg := range input.Legs {
+		field := fmt.Sprintf("Legs[%d]", i)
+		v.requireID(field+".Receiver", leg.Receiver)
+		v.requireID(field+".Id", leg.ID)
+	}
+	return v.err()
+}
+
+func validateMintBatchInput(input *MINTBATCH) error {
+	var v inputValidator
+	v.requireTxnID("TxnId", input.TxnID)
+	v.requireID("Id", input.ID)
+	return v.err()
+}
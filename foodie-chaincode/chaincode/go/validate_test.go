@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestDecodeInputRejectsUnknownFieldsAndTrailingData(t *testing.T) {
+	for _, input := range []string{
+		`{"TxnId": "t1", "Id": "MEAL", "Amont": "5"}`,
+		`{"TxnId": "t1", "Id": "MEAL"} {"TxnId": "t2"}`,
+		`{"TxnId": "t1", "Id": "MEAL", "Amount": "1.5"}`,
+	} {
+		var transfer TRANSFER
+		err := decodeInput(input, &transfer)
+		if err == nil {
+			t.Fatalf("expected %s to be rejected", input)
+		}
+		if decoded := decodeChaincodeError(t, err); decoded.Code != ERRINVALIDINPUT {
+			t.Fatalf("expected code %s, got %s", ERRINVALIDINPUT, decoded.Code)
+		}
+	}
+}
+
+func TestValidateTransferInputReportsEveryFailure(t *testing.T) {
+	err := validateTransferInput(&TRANSFER{TxnID: "t 1", ID: "", UserId: "alice", Receiver: "alice", Amount: "0"})
+	if err == nil {
+		t.Fatal("expected the transfer to be rejected")
+	}
+
+	decoded := decodeChaincodeError(t, err)
+	if decoded.Code != ERRINVALIDINPUT {
+		t.Fatalf("expected code %s, got %s", ERRINVALIDINPUT, decoded.Code)
+	}
+	for _, field := range []string{"TxnId", "Id", "Receiver", "Amount"} {
+		if _, ok := decoded.Details[field]; !ok {
+			t.Fatalf("expected a failure for %s, got %v", field, decoded.Details)
+		}
+	}
+	if _, ok := decoded.Details["UserId"]; ok {
+		t.Fatalf("did not expect a failure for UserId, got %s", decoded.Details["UserId"])
+	}
+}
+
+func TestValidateInputsAcceptValidPayloads(t *testing.T) {
+	if err := validateMintInput(&FOODIE{TxnID: "mint-1", ID: "MEAL", UserId: "alice@campus", Amount: "10"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := validateTransferInput(&TRANSFER{TxnID: "xfer-1", ID: "MEAL", UserId: "alice", Receiver: "bob", Amount: "5"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := validateBurnInput(&BURNTOKEN{TxnID: "burn-1", ID: "MEAL", BurnTokenID: "alice", BurnTokenAmount: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := validatePayInput(&PAYMENT{TxnID: "pay-1", ID: "MEAL", UserId: "alice", MerchantID: "canteen", OrderRef: "order #12", Items: []LINEITEM{{Description: "Thali", Quantity: 2, UnitPrice: "40"}, {Description: "Tea", Quantity: 1, UnitPrice: "10"}}, Total: "90"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidatePayInputChecksTotal(t *testing.T) {
+	payment := &PAYMENT{TxnID: "pay-1", ID: "MEAL", UserId: "alice", MerchantID: "canteen", OrderRef: "order #12", Items: []LINEITEM{{Description: "Thali", Quantity: 2, UnitPrice: "40"}}, Total: "40"}
+	decoded := decodeChaincodeError(t, validatePayInput(payment))
+	if decoded.Code != ERRINVALIDINPUT || decoded.Details["Total"] != "must equal the sum of the items, 80" {
+		t.Fatalf("expected a Total mismatch, got %v", decoded)
+	}
+
+	payment.Items = []LINEITEM{{Description: " ", Quantity: 0, UnitPrice: "40"}}
+	decoded = decodeChaincodeError(t, validatePayInput(payment))
+	for _, field := range []string{"Items[0].Description", "Items[0].Quantity"} {
+		if _, ok := decoded.Details[field]; !ok {
+			t.Fatalf("expected a failure for %s, got %v", field, decoded.Details)
+		}
+	}
+	if _, ok := decoded.Details["Total"]; ok {
+		t.Fatalf("did not expect a Total mismatch for invalid items, got %s", decoded.Details["Total"])
+	}
+}
+
+func TestValidateIDLimits(t *testing.T) {
+	err := validateBurnInput(&BURNTOKEN{TxnID: "burn-1", ID: strings.Repeat("M", MAXIDLENGTH+1), BurnTokenID: "al\x00ice", BurnTokenAmount: "1"})
+	if err == nil {
+		t.Fatal("expected the burn to be rejected")
+	}
+
+	decoded := decodeChaincodeError(t, err)
+	if len(decoded.Details) != 2 {
+		t.Fatalf("expected failures for Id and BurnTokenId, got %v", decoded.Details)
+	}
+}
+
+func TestValidateTransferBatchInputChecksTxnIdAndLegs(t *testing.T) {
+	err := validateTransferBatchInput(&TRANSFERBATCH{UserId: "alice", Legs: []TRANSFERLEG{{Receiver: "", ID: "MEAL", Amount: "1"}}})
+	decoded := decodeChaincodeError(t, err)
+	for _, field := range []string{"TxnId", "Legs[0].Receiver"} {
+		if _, ok := decoded.Details[field]; !ok {
+			t.Fatalf("expected a failure for %s, got %v", field, decoded.Details)
+		}
+	}
+
+	// Leg ids cannot be submitted as the TxnId of another request
+	var v inputValidator
+	v.requireTxnID("TxnId", legTxnID("payout", 0))
+	if v.err() == nil {
+		t.Fatalf("expected leg id %s to be rejected as a client TxnId", legTxnID("payout", 0))
+	}
+}
+
+func TestValidateMintBatchInputChecksTxnIdAndId(t *testing.T) {
+	err := validateMintBatchInput(&MINTBATCH{TxnID: "", ID: "MEAL", Rows: []json.RawMessage{json.RawMessage(`{"UserId": "alice", "Amount": "1"}`)}})
+	decoded := decodeChaincodeError(t, err)
+	if _, ok := decoded.Details["TxnId"]; !ok {
+		t.Fatalf("expected a failure for TxnId, got %v", decoded.Details)
+	}
+
+	if err := validateMintBatchInput(&MINTBATCH{TxnID: "roster-1", ID: "MEAL"}); err != nil {
+		t.Fatal(err)
+	}
+}
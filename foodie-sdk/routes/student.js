@@ -11,9 +11,14 @@ router.post("/mint", studentController.mintToken );//done
 router.post("/transfer", studentController.transferToken );//done
 router.post("/burn", studentController.burnToken );//done
 router.post("/getBalance", studentController.getBalance); // done
+router.post("/getReceipt", studentController.getReceipt );
 router.post("/getQuery", studentController.getQuery );
 router.post("/getAllOwner", studentController.getAllOwner );
 router.post("/getHistory", studentController.getHistory );
+router.post("/pay", studentController.pay );
+router.post("/refund", studentController.refund );
+router.post("/listSales", studentController.listSales );
+router.post("/listPurchases", studentController.listPurchases );
 
 
 
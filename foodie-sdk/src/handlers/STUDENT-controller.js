@@ -9,7 +9,7 @@
 const { Gateway, Wallets } = require('fabric-network');
 const path = require('path');
 const fs = require('fs');
-const { getContractObject } = require('../../utils/util.js');
+const { getContractObject, getChaincodeError } = require('../../utils/util.js');
 const { NETWORK_PARAMETERS, DOCTYPE } = require('../../utils/Constants.js');
 const logger = require('../../logger/index.js')(module);
 // const { formatReferences, formatAssetInput } = require('../../utils/FormatStruct');
@@ -44,20 +44,26 @@ class TokenController {
 			let contract = await getContractObject(org, user, NETWORK_PARAMETERS.CHANNEL_NAME, NETWORK_PARAMETERS.CHAINCODE_NAME, gateway)
 			// let tokenData = JSON.parse(tokenDef);
 			console.log(`----------Minting Start details------------`, org)
-			let stateTxn = contract.createTransaction('Mint');
+			let stateTxn = contract.createTransaction('MintTyped');
 			// let tx = await stateTxn.submit(JSON.stringify(tokenDef));
 
 			console.log("--------------------------------------");
 			
 	
-			let tx = await stateTxn.submit(JSON.stringify(tokenDef));
+			// MintTyped takes a typed payload: only schema fields, amounts as strings
+			let mintInput = { TxnId: txId, Id: id, UserId: user, Amount: String(amount) }
+			if (org) mintInput.OrgName = org
+			if (docType) mintInput.DocType = docType
+			if (req.body.ExpiresAt) mintInput.ExpiresAt = req.body.ExpiresAt
+			let tx = await stateTxn.submit(JSON.stringify(mintInput));
 			// let tx = await stateTxn.submit(tokenDef)
 			console.log(`----------Minting Done Successfully & Minted Token - ${tx} ----------`);
 			// let tx ='xxxxxxxxxxxxxxxxx'
 			return res.status(200).send({
 				status: true,
 				message: `Minting Done Successfully & Minted Token -} `,
-				txid: tx.toString()
+				txid: tx.toString(),
+				receipt: JSON.parse(tx.toString())
 			});
 
 			
@@ -66,9 +72,12 @@ class TokenController {
 		} catch (error) {
 			console.log(error.message)
 			logger.error({ userInfo: req.loggerInfo, method: 'mintToken', error })
-			return res.status(500).send({
+			const chaincodeError = getChaincodeError(error)
+			return res.status(chaincodeError.status).send({
 				status: false,
-				message: error.message
+				code: chaincodeError.code,
+				message: chaincodeError.message,
+				details: chaincodeError.details
 			});
 		}
 	}
@@ -92,7 +101,7 @@ class TokenController {
 			let contract = await getContractObject(org, user, NETWORK_PARAMETERS.CHANNEL_NAME, NETWORK_PARAMETERS.CHAINCODE_NAME, gateway)
 			// let tokenData = JSON.parse(tokenDef);
 			console.log('----------Transfer Token Details------------\n', org)
-			let stateTxn = contract.createTransaction('Transfer');
+			let stateTxn = contract.createTransaction('TransferTyped');
 			// let tx = await stateTxn.submit(JSON.stringify(tokenDef));
 
 			console.log("--------------------------------------");
@@ -101,14 +110,19 @@ class TokenController {
 			console.log("--------------------------------------");
 			
 			
-				let tx = await stateTxn.submit(JSON.stringify(tokenDef));
+				// TransferTyped takes a typed payload: only schema fields, amounts as strings
+				let transferInput = { TxnId: txId, Id: id, UserId: user, Receiver: receiver, Amount: String(amount) }
+				if (docType) transferInput.DocType = docType
+				if (req.body.Inputs) transferInput.Inputs = req.body.Inputs
+				let tx = await stateTxn.submit(JSON.stringify(transferInput));
 			// let tx = await stateTxn.submit(receiver,amount)
 			console.log(`---------- Transfer Done Successfully & From Minter to ${tokenDef.receiver} Token sent- ${tokenDef.amount} ----------`);
 			// let tx ='xxxxxxxxxxxxxxxxx'
 			return res.status(200).send({
 				status: true,
 				message: `Successfully Transferred Token to ${tokenDef.receiver}`,
-				txid: tx.toString()
+				txid: tx.toString(),
+				receipt: JSON.parse(tx.toString())
 			});
 
 			
@@ -117,9 +131,12 @@ class TokenController {
 		} catch (error) {
 			console.log(error.message)
 			logger.error({ userInfo: req.loggerInfo, method: 'transferToken', error })
-			return res.status(500).send({
+			const chaincodeError = getChaincodeError(error)
+			return res.status(chaincodeError.status).send({
 				status: false,
-				message: error.message
+				code: chaincodeError.code,
+				message: chaincodeError.message,
+				details: chaincodeError.details
 			});
 		}
 	}
@@ -147,20 +164,26 @@ class TokenController {
 			let contract = await getContractObject(org, user, NETWORK_PARAMETERS.CHANNEL_NAME, NETWORK_PARAMETERS.CHAINCODE_NAME, gateway)
 			// // let tokenData = JSON.parse(tokenDef);
 			console.log(`----------Burn Token details------------`, org)
-			let stateTxn = contract.createTransaction('Burn');
+			let stateTxn = contract.createTransaction('BurnTyped');
 			// // let tx = await stateTxn.submit(JSON.stringify(tokenDef));
 
 			// console.log("--------------------------------------");
 			
 	
-			let tx = await stateTxn.submit(JSON.stringify(tokenDef));
+			// BurnTyped takes a typed payload: only schema fields, amounts as strings
+			let burnInput = { TxnId: txId, Id: id, BurnTokenId: burnTokenId, BurnTokenAmount: String(burnTokenAmount) }
+			if (org) burnInput.OrgName = org
+			if (user) burnInput.UserId = user
+			if (docType) burnInput.DocType = docType
+			let tx = await stateTxn.submit(JSON.stringify(burnInput));
 			// let tx = await stateTxn.submit(tokenDef)
 			console.log(`----------Burnt Token  Successfully ----------`);
 			// let tx ='xxxxxxxxxxxxxxxxx'
 			return res.status(200).send({
 				status: true,
 				message: `Burn Token Successfully `,
-				txid: tx.toString()
+				txid: tx.toString(),
+				receipt: JSON.parse(tx.toString())
 			});
 
 			
@@ -169,9 +192,12 @@ class TokenController {
 		} catch (error) {
 			console.log(error.message)
 			logger.error({ userInfo: req.loggerInfo, method: 'burnToken', error })
-			return res.status(500).send({
+			const chaincodeError = getChaincodeError(error)
+			return res.status(chaincodeError.status).send({
 				status: false,
-				message: error.message
+				code: chaincodeError.code,
+				message: chaincodeError.message,
+				details: chaincodeError.details
 			});
 		}
 	}
@@ -218,9 +244,168 @@ class TokenController {
 		} catch (error) {
 			console.log(error.message)
 			logger.error({ userInfo: req.loggerInfo, method: 'getBalance', error })
-			return res.status(500).send({
+			const chaincodeError = getChaincodeError(error)
+			return res.status(chaincodeError.status).send({
+				status: false,
+				code: chaincodeError.code,
+				message: chaincodeError.message,
+				details: chaincodeError.details
+			});
+		}
+	}
+
+	// Returns the receipt of a Mint, Transfer, Burn, Pay or batch by its TxnId. After a gateway
+	// timeout, a client should read the receipt before resubmitting; resubmitting the identical
+	// request is also safe and returns the same receipt.
+	async getReceipt(req, res, next) {
+		try {
+			let org = req.body.OrgName
+			let user = req.body.UserId;
+			let txId = req.body.TxnId;
+
+			const gateway = new Gateway();
+			let contract = await getContractObject(org, user, NETWORK_PARAMETERS.CHANNEL_NAME, NETWORK_PARAMETERS.CHAINCODE_NAME, gateway)
+			let tx = await contract.evaluateTransaction('GetReceipt', txId)
+			return res.status(200).send({
+				status: true,
+				message: `Receipt fetch successfully `,
+				receipt: JSON.parse(tx.toString())
+			});
+		} catch (error) {
+			console.log(error.message)
+			logger.error({ userInfo: req.loggerInfo, method: 'getReceipt', error })
+			const chaincodeError = getChaincodeError(error)
+			return res.status(chaincodeError.status).send({
+				status: false,
+				code: chaincodeError.code,
+				message: chaincodeError.message,
+				details: chaincodeError.details
+			});
+		}
+	}
+
+	// Pays a registered merchant for an order. Items is a list of { Description, Quantity,
+	// UnitPrice } and Total must equal their sum.
+	async pay(req, res, next) {
+		try {
+			let org = req.body.OrgName
+			let user = req.body.UserId;
+			let items = (req.body.Items || []).map((item) => ({
+				Description: item.Description,
+				Quantity: Number(item.Quantity),
+				UnitPrice: String(item.UnitPrice)
+			}))
+
+			const gateway = new Gateway();
+			let contract = await getContractObject(org, user, NETWORK_PARAMETERS.CHANNEL_NAME, NETWORK_PARAMETERS.CHAINCODE_NAME, gateway)
+			// Pay takes a typed payload: only schema fields, amounts as strings
+			let payInput = {
+				TxnId: req.body.TxnId,
+				Id: req.body.Id,
+				UserId: user,
+				MerchantId: req.body.MerchantId,
+				OrderRef: req.body.OrderRef,
+				Items: items,
+				Total: String(req.body.Total)
+			}
+			let tx = await contract.createTransaction('Pay').submit(JSON.stringify(payInput));
+			return res.status(200).send({
+				status: true,
+				message: `Paid merchant ${payInput.MerchantId} successfully `,
+				txid: tx.toString(),
+				receipt: JSON.parse(tx.toString())
+			});
+		} catch (error) {
+			console.log(error.message)
+			logger.error({ userInfo: req.loggerInfo, method: 'pay', error })
+			const chaincodeError = getChaincodeError(error)
+			return res.status(chaincodeError.status).send({
+				status: false,
+				code: chaincodeError.code,
+				message: chaincodeError.message,
+				details: chaincodeError.details
+			});
+		}
+	}
+
+	// Refunds part or all of an earlier transfer or payment. Only the merchant that was paid or an
+	// Admin can refund. TxnId identifies the refund, so resubmitting it returns the same receipt.
+	async refund(req, res, next) {
+		try {
+			let org = req.body.OrgName
+			let user = req.body.UserId;
+
+			const gateway = new Gateway();
+			let contract = await getContractObject(org, user, NETWORK_PARAMETERS.CHANNEL_NAME, NETWORK_PARAMETERS.CHAINCODE_NAME, gateway)
+			let tx = await contract.createTransaction('Refund').submit(req.body.TxnId, req.body.OriginalTxnId, String(req.body.Amount), req.body.Reason);
+			return res.status(200).send({
+				status: true,
+				message: `Refunded transaction ${req.body.OriginalTxnId} successfully `,
+				receipt: JSON.parse(tx.toString())
+			});
+		} catch (error) {
+			console.log(error.message)
+			logger.error({ userInfo: req.loggerInfo, method: 'refund', error })
+			const chaincodeError = getChaincodeError(error)
+			return res.status(chaincodeError.status).send({
+				status: false,
+				code: chaincodeError.code,
+				message: chaincodeError.message,
+				details: chaincodeError.details
+			});
+		}
+	}
+
+	// Lists the itemised sales of a merchant.
+	async listSales(req, res, next) {
+		try {
+			let org = req.body.OrgName
+			let user = req.body.UserId;
+
+			const gateway = new Gateway();
+			let contract = await getContractObject(org, user, NETWORK_PARAMETERS.CHANNEL_NAME, NETWORK_PARAMETERS.CHAINCODE_NAME, gateway)
+			let tx = await contract.evaluateTransaction('ListSales', req.body.MerchantId)
+			return res.status(200).send({
+				status: true,
+				message: `Sales fetch successfully `,
+				sales: JSON.parse(tx.toString())
+			});
+		} catch (error) {
+			console.log(error.message)
+			logger.error({ userInfo: req.loggerInfo, method: 'listSales', error })
+			const chaincodeError = getChaincodeError(error)
+			return res.status(chaincodeError.status).send({
+				status: false,
+				code: chaincodeError.code,
+				message: chaincodeError.message,
+				details: chaincodeError.details
+			});
+		}
+	}
+
+	// Lists the itemised purchases paid from an account.
+	async listPurchases(req, res, next) {
+		try {
+			let org = req.body.OrgName
+			let user = req.body.UserId;
+
+			const gateway = new Gateway();
+			let contract = await getContractObject(org, user, NETWORK_PARAMETERS.CHANNEL_NAME, NETWORK_PARAMETERS.CHAINCODE_NAME, gateway)
+			let tx = await contract.evaluateTransaction('ListPurchases', req.body.Account || user)
+			return res.status(200).send({
+				status: true,
+				message: `Purchases fetch successfully `,
+				purchases: JSON.parse(tx.toString())
+			});
+		} catch (error) {
+			console.log(error.message)
+			logger.error({ userInfo: req.loggerInfo, method: 'listPurchases', error })
+			const chaincodeError = getChaincodeError(error)
+			return res.status(chaincodeError.status).send({
 				status: false,
-				message: error.message
+				code: chaincodeError.code,
+				message: chaincodeError.message,
+				details: chaincodeError.details
 			});
 		}
 	}
@@ -249,20 +434,14 @@ class TokenController {
 			let contract = await getContractObject(org, user, NETWORK_PARAMETERS.CHANNEL_NAME, NETWORK_PARAMETERS.CHAINCODE_NAME, gateway)
 			// // let tokenData = JSON.parse(tokenDef);
 			console.log(`----------GetQuery Token details------------`, org)
-			let stateTxn = contract.createTransaction('GetQuery');
-			// // let tx = await stateTxn.submit(JSON.stringify(tokenDef));
-
-			// console.log("--------------------------------------");
-			
-	
-			// let tx = await stateTxn.submit(JSON.stringify(tokenDef.docType));
-			let tx = await stateTxn.submit(docType)
+			let pageSize = String(req.body.PageSize || 50)
+			let bookmark = req.body.Bookmark || ''
+			let tx = await contract.evaluateTransaction('QueryByDocType', docType, pageSize, bookmark)
 			console.log(`----------GetQuery Token  Successfully ----------`);
-			// let tx ='xxxxxxxxxxxxxxxxx'
 			return res.status(200).send({
 				status: true,
 				message: `GetQuery Fetch Successfully `,
-				txid: tx.toString()
+				data: JSON.parse(tx.toString())
 			});
 
 			
@@ -271,9 +450,12 @@ class TokenController {
 		} catch (error) {
 			console.log(error.message)
 			logger.error({ userInfo: req.loggerInfo, method: 'getQuery', error })
-			return res.status(500).send({
+			const chaincodeError = getChaincodeError(error)
+			return res.status(chaincodeError.status).send({
 				status: false,
-				message: error.message
+				code: chaincodeError.code,
+				message: chaincodeError.message,
+				details: chaincodeError.details
 			});
 		}
 	}
@@ -292,20 +474,14 @@ class TokenController {
 			let contract = await getContractObject(org, user, NETWORK_PARAMETERS.CHANNEL_NAME, NETWORK_PARAMETERS.CHAINCODE_NAME, gateway)
 			// // let tokenData = JSON.parse(tokenDef);
 			console.log(`----------getAllOwner details------------`, org)
-			let stateTxn = contract.createTransaction('GetAllOwners');
-			// // let tx = await stateTxn.submit(JSON.stringify(tokenDef));
-
-			// console.log("--------------------------------------");
-			
-	
-			// let tx = await stateTxn.submit(JSON.stringify(tokenDef.docType));
-			let tx = await stateTxn.submit(docType)
+			let pageSize = String(req.body.PageSize || 50)
+			let bookmark = req.body.Bookmark || ''
+			let tx = await contract.evaluateTransaction('QueryByDocType', docType || 'OWNER', pageSize, bookmark)
 			console.log(`----------getAllOwner Fetch  Successfully ----------`);
-			// let tx ='xxxxxxxxxxxxxxxxx'
 			return res.status(200).send({
 				status: true,
 				message: `getAllOwner Fetch Successfully `,
-				txid: tx.toString()
+				data: JSON.parse(tx.toString())
 			});
 
 			
@@ -314,9 +490,12 @@ class TokenController {
 		} catch (error) {
 			console.log(error.message)
 			logger.error({ userInfo: req.loggerInfo, method: 'getAllOwner', error })
-			return res.status(500).send({
+			const chaincodeError = getChaincodeError(error)
+			return res.status(chaincodeError.status).send({
 				status: false,
-				message: error.message
+				code: chaincodeError.code,
+				message: chaincodeError.message,
+				details: chaincodeError.details
 			});
 		}
 	}
@@ -369,9 +548,12 @@ class TokenController {
 		} catch (error) {
 			console.log(error.message)
 			logger.error({ userInfo: req.loggerInfo, method: 'getHistory', error })
-			return res.status(500).send({
+			const chaincodeError = getChaincodeError(error)
+			return res.status(chaincodeError.status).send({
 				status: false,
-				message: error.message
+				code: chaincodeError.code,
+				message: chaincodeError.message,
+				details: chaincodeError.details
 			});
 		}
 	}
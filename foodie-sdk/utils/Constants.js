@@ -58,8 +58,22 @@ const NETWORK_PARAMETERS = {
     CHANNEL_NAME: process.env.SETUP == SETUP_TYPE.K8S ? 'spydra-channel': 'mychannel'
 }
 
+// HTTP status for each error code returned by the chaincode. Errors without a code are internal.
+const CHAINCODE_ERROR_STATUS = {
+    UNAUTHORIZED: 403,
+    FROZEN: 403,
+    NOT_FOUND: 404,
+    DUPLICATE: 409,
+    CONFLICT: 409,
+    INSUFFICIENT_FUNDS: 409,
+    PAUSED: 409,
+    INVALID_INPUT: 400,
+    INTERNAL: 500
+}
+
 module.exports = {
     NETWORK_PARAMETERS,
+    CHAINCODE_ERROR_STATUS,
     SETUP_TYPE,
     ENVIRONMENTS,
     WHITE_LISTED_DOMAINS,
@@ -2,7 +2,7 @@ const { Gateway, Wallet, Wallets } = require("fabric-network");
 const { buildWallet, buildCCPOrg, getOrgName } = require("./AppUtil");
 const path = require('path');
 const config = require('../config/cred');
-const { SETUP_TYPE } = require("./Constants");
+const { SETUP_TYPE, CHAINCODE_ERROR_STATUS } = require("./Constants");
 const logger = require('../logger')(module);
 
 const getContractObject = async (orgName, user, channelName, contractName, gateway) => {
@@ -30,8 +30,54 @@ const getContractObject = async (orgName, user, channelName, contractName, gatew
   return contract
 }
 
+// Finds the first chaincode error ({"code", "message", "details"}) in text, which may be an
+// endorsement error message wrapping the chaincode response.
+const parseChaincodeError = (text) => {
+  if (typeof text !== 'string') {
+    return null
+  }
 
+  let start = text.indexOf('{"code":')
+  while (start !== -1) {
+    for (let end = text.indexOf('}', start); end !== -1; end = text.indexOf('}', end + 1)) {
+      try {
+        const parsed = JSON.parse(text.substring(start, end + 1))
+        if (parsed.code) {
+          return parsed
+        }
+      } catch (e) {
+        // keep looking for the closing brace
+      }
+    }
+    start = text.indexOf('{"code":', start + 1)
+  }
+  return null
+}
+
+// Maps an error thrown by a transaction to an HTTP status and the chaincode error model.
+// Errors the chaincode did not type are reported as INTERNAL.
+const getChaincodeError = (error) => {
+  let chaincodeError = parseChaincodeError(error.message)
+
+  const responses = error.responses || error.endorsements || []
+  for (let i = 0; !chaincodeError && i < responses.length; i++) {
+    const response = responses[i].response || responses[i]
+    chaincodeError = parseChaincodeError(response.message)
+  }
+
+  if (!chaincodeError) {
+    chaincodeError = { code: 'INTERNAL', message: error.message }
+  }
+
+  return {
+    status: CHAINCODE_ERROR_STATUS[chaincodeError.code] || 500,
+    code: chaincodeError.code,
+    message: chaincodeError.message,
+    details: chaincodeError.details || {}
+  }
+}
 
 module.exports = {
   getContractObject,
+  getChaincodeError,
 }
\ No newline at end of file